@@ -1,8 +1,8 @@
 package story
 
 import (
-	"bytes"
-	"encoding/json"
+	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,11 +12,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/term"
 )
 
 const AUTH_REDIRECT_CONTENT = `
@@ -50,34 +50,49 @@ const AUTH_REDIRECT_CONTENT = `
 </html>
 `
 
+// defaultAuthTimeout is seconds to wait for authorization.
+const defaultAuthTimeout = 300
+
 type InitConfig struct {
 	RedirectPort int
 	RedirectPath string
 	ClientID     string
 	ClientSecret string
 	AccessToken  string
-}
+	DefaultBlog  string
+	AuthTimeout  int
 
-func (c *InitConfig) Load() error {
-	confFile := filepath.Join(filepath.Dir(os.Args[0]), "story.conf")
-	f, err := os.Open(confFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	// RetryAttempts, RetryDelay and RetryUnsafe override DefaultRetryPolicy if set.
+	// Retry is used instead of them if not nil.
+	RetryAttempts int    `json:",omitempty"`
+	RetryDelay    string `json:",omitempty"`
+	RetryUnsafe   bool   `json:",omitempty"`
 
-	return json.NewDecoder(f).Decode(c)
-}
+	// EncryptedSecrets holds ClientSecret and AccessToken if encryption is enabled.
+	EncryptedSecrets  *EncryptedSecrets `json:",omitempty"`
+	PassphraseCommand string            `json:",omitempty"`
 
-func (c *InitConfig) Save() error {
-	confFile := filepath.Join(filepath.Dir(os.Args[0]), "story.conf")
-	f, err := os.Create(confFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	Retry *RetryPolicy `json:"-"`
+
+	// Path is the config file path. DefaultConfigPath is used if empty.
+	Path string `json:"-"`
+	// Profile selects config in the file. DefaultProfile is used if empty.
+	Profile string `json:"-"`
 
-	return json.NewEncoder(f).Encode(c)
+	// OAuthURL is the root of OAuth endpoints. DefaultOAuthURL is used if empty.
+	OAuthURL string `json:"-"`
+
+	// Browser opens authorization url. SystemBrowser is used if nil.
+	Browser   Browser `json:"-"`
+	NoBrowser bool    `json:"-"`
+	Manual    bool    `json:"-"`
+	// Encrypt enables encryption of secrets on save.
+	Encrypt bool `json:"-"`
+
+	passphrase    []byte
+	saved         *secrets
+	secretFromEnv bool
+	tokenFromEnv  bool
 }
 
 func (c *InitConfig) Parse(args []string) error {
@@ -85,6 +100,7 @@ func (c *InitConfig) Parse(args []string) error {
 	flag.IntVar(&c.RedirectPort, "rdport", 18769, "redirection uri port")
 	flag.StringVar(&c.RedirectPath, "rdpath", "oauth_result", "path of redirection uri")
 	flag.StringVar(&c.ClientSecret, "secret", "", "tistory client secret")
+	c.addAuthFlags(flag)
 
 	if err := flag.Parse(args); err != nil {
 		return err
@@ -97,53 +113,51 @@ func (c *InitConfig) Parse(args []string) error {
 	return nil
 }
 
-func runOAuthServer(port int, path string, clientID string, clientSecret string, redirectURI string) (*http.Server, chan string) {
-	chanResult := make(chan string)
+// ParseAuth parses options of `story auth`.
+func (c *InitConfig) ParseAuth(args []string) error {
+	flag := flag.NewFlagSet("story auth", flag.ExitOnError)
+	c.addAuthFlags(flag)
+
+	return flag.Parse(args)
+}
+
+func (c *InitConfig) addAuthFlags(flag *flag.FlagSet) {
+	if c.AuthTimeout <= 0 {
+		c.AuthTimeout = defaultAuthTimeout
+	}
+
+	flag.IntVar(&c.AuthTimeout, "timeout", c.AuthTimeout, "seconds to wait for authorization")
+	flag.BoolVar(&c.NoBrowser, "no-browser", false, "print authorization url instead of opening browser")
+	flag.BoolVar(&c.Encrypt, "encrypt", false, "encrypt client secret and access token with passphrase")
+	flag.StringVar(&c.PassphraseCommand, "passphrase-command", c.PassphraseCommand, "command which prints passphrase, ex> \"pass show story\"")
+	flag.BoolVar(&c.Manual, "manual", false, "paste the redirected url instead of running local server, for ssh sessions")
+}
+
+func runOAuthServer(port int, path string, state string, exchange func(code string) (string, error)) (*http.Server, chan string) {
+	chanResult := make(chan string, 1)
 
 	var server http.Server
 	server.Addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
 	server.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		log.Println("access", req.URL)
 		if req.URL.Path[1:] == path {
-
-			exchangeCode := req.URL.Query().Get("code")
-			exchangeQuery := url.Values{}
-			exchangeQuery.Add("client_id", clientID)
-			exchangeQuery.Add("client_secret", clientSecret)
-			exchangeQuery.Add("redirect_uri", redirectURI)
-			exchangeQuery.Add("code", exchangeCode)
-			exchangeQuery.Add("grant_type", "authorization_code")
-
-			exchangeAddr := "https://www.tistory.com/oauth/access_token?" + exchangeQuery.Encode()
-			log.Println(exchangeAddr)
-
-			exchangeResp, err := http.Get(exchangeAddr)
-			if err != nil {
+			if req.URL.Query().Get("state") != state {
 				res.WriteHeader(http.StatusBadRequest)
-				res.Write([]byte(fmt.Sprintf(`<script> alert('Authentication failed: %s'); setTimeout(window.close, 1); </script>`, err.Error())))
+				res.Write([]byte(`<script> alert('Authentication failed: state mismatch'); setTimeout(window.close, 1); </script>`))
 				return
 			}
 
-			// read body
-			var buffer bytes.Buffer
-			defer exchangeResp.Body.Close()
-
-			if _, err := io.Copy(&buffer, exchangeResp.Body); err != nil {
+			accessToken, err := exchange(req.URL.Query().Get("code"))
+			if err != nil {
 				res.WriteHeader(http.StatusBadRequest)
 				res.Write([]byte(fmt.Sprintf(`<script> alert('Authentication failed: %s'); setTimeout(window.close, 1); </script>`, err.Error())))
 				return
 			}
 
-			if exchangeResp.StatusCode != http.StatusOK {
-				res.WriteHeader(http.StatusBadRequest)
-				res.Write([]byte(fmt.Sprintf(`<script> alert('Authentication failed: %s'); setTimeout(window.close, 1); </script>`, buffer.String())))
-				return
-			}
-
 			res.WriteHeader(http.StatusOK)
 			res.Write([]byte(`<script> alert('Authentication success'); setTimeout(window.close, 1); </script>`))
 
-			chanResult <- strings.Split(buffer.String(), "=")[1]
+			chanResult <- accessToken
 
 		} else {
 			http.NotFound(res, req)
@@ -155,31 +169,215 @@ func runOAuthServer(port int, path string, clientID string, clientSecret string,
 	return &server, chanResult
 }
 
-func (config *InitConfig) Authorize() error {
-	// run server to accept redirect_uri
-	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/%s", config.RedirectPort, config.RedirectPath)
-	server, chanString := runOAuthServer(config.RedirectPort, config.RedirectPath, config.ClientID, config.ClientSecret, redirectURI)
-	defer server.Close()
+// Authorize runs local server to accept redirection, and waits for access token
+// until timeout or ctx is done.
+func (config *InitConfig) Authorize(ctx context.Context) error {
+	state, err := newOAuthState()
+	if err != nil {
+		return err
+	}
 
-	authQuery := url.Values{}
-	authQuery.Add("response_type", "code")
-	authQuery.Add("client_id", config.ClientID)
-	authQuery.Add("redirect_uri", redirectURI)
+	// run server to accept redirect_uri
+	server, chanString := runOAuthServer(config.RedirectPort, config.RedirectPath, state, config.exchangeCode)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
 
-	authAddr := "https://www.tistory.com/oauth/authorize?" + authQuery.Encode()
+	authAddr := config.authorizeURL(state)
 	log.Println(authAddr)
 
 	// open browser to authorize
-	exec.Command("rundll32", "url.dll,FileProtocolHandler", authAddr).Start()
+	browser := config.Browser
+	if config.NoBrowser {
+		browser = PrintBrowser{Out: os.Stderr}
+	} else if browser == nil {
+		browser = SystemBrowser{}
+	}
+	if err := browser.Open(authAddr); err != nil {
+		log.Println("failed to open browser:", err)
+		PrintBrowser{Out: os.Stderr}.Open(authAddr)
+	}
 
 	// wait for access token
-	timer := time.NewTimer(10 * time.Second)
-	select {
-	case config.AccessToken = <-chanString:
-		log.Println("authorization code is", config.AccessToken)
-	case <-timer.C:
-		return errors.New("OAuth timeout")
+	timeout := time.Duration(config.AuthTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// redraw remaining time only on terminal, not to flood logs
+	redraw := term.IsTerminal(int(os.Stderr.Fd()))
+	if !redraw {
+		fmt.Fprintln(os.Stderr, "waiting for authorization until", deadline.Format("15:04:05"))
+	}
+
+	for {
+		if redraw {
+			fmt.Fprintf(os.Stderr, "\rwaiting for authorization... %s left ", time.Until(deadline).Round(time.Second))
+		}
+
+		select {
+		case config.AccessToken = <-chanString:
+			config.tokenFromEnv = false
+			if redraw {
+				fmt.Fprintln(os.Stderr)
+			}
+			log.Println("authorized")
+			return config.Save()
+
+		case <-ticker.C:
+
+		case <-ctx.Done():
+			if redraw {
+				fmt.Fprintln(os.Stderr)
+			}
+			if ctx.Err() == context.DeadlineExceeded {
+				return errors.New("OAuth timeout")
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// AuthorizeManual authorizes without local redirection server.
+// User opens the printed url anywhere and pastes the redirected url, or the code in it.
+func (config *InitConfig) AuthorizeManual(in io.Reader, out io.Writer) error {
+	state, err := newOAuthState()
+	if err != nil {
+		return err
+	}
+
+	PrintBrowser{Out: out}.Open(config.authorizeURL(state))
+	fmt.Fprintln(out, "After authorization, the browser is redirected to an unreachable page.")
+	fmt.Fprint(out, "Paste the url of the page, or the code in it: ")
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+
+	code, returnedState := parseAuthorizationCode(line)
+	if returnedState != "" && returnedState != state {
+		return errors.New("state mismatch, the url is not from this authorization")
 	}
 
+	accessToken, err := config.exchangeCode(code)
+	if err != nil {
+		return err
+	}
+
+	config.AccessToken = accessToken
+	config.tokenFromEnv = false
 	return config.Save()
 }
+
+// parseAuthorizationCode extracts code and state from redirected url,
+// or returns input as code if it is not an url.
+func parseAuthorizationCode(input string) (code string, state string) {
+	input = strings.TrimSpace(input)
+	if u, err := url.Parse(input); err == nil && u.Query().Get("code") != "" {
+		return u.Query().Get("code"), u.Query().Get("state")
+	}
+
+	return input, ""
+}
+
+// client returns client of the access token, with Retry or retry settings of the config.
+func (config *InitConfig) client() *Client {
+	if config.Retry != nil {
+		return newClient(config.AccessToken, "", config.Retry)
+	}
+
+	policy, err := config.RetryPolicy()
+	if err != nil {
+		log.Println(err)
+		return NewClient(config.AccessToken, "")
+	}
+	return newClient(config.AccessToken, "", &policy)
+}
+
+// SelectDefaultBlog asks user to pick the default blog from blog/info and saves it.
+func (config *InitConfig) SelectDefaultBlog(in io.Reader, out io.Writer) error {
+	info, err := config.client().BlogInfo()
+	if err != nil {
+		return err
+	}
+
+	if len(info.Blogs) == 0 {
+		return errors.New("no blogs found")
+	}
+
+	current := -1
+	for i, blog := range info.Blogs {
+		// the current default blog, or the blog marked as default
+		if blog.Name == config.DefaultBlog || (current < 0 && blog.Default == "Y") {
+			current = i
+		}
+		fmt.Fprintf(out, "%d) %s - %s\n", i+1, blog.Name, blog.Title)
+	}
+
+	for {
+		if current >= 0 {
+			fmt.Fprintf(out, "Default blog [%d]: ", current+1)
+		} else {
+			fmt.Fprint(out, "Default blog: ")
+		}
+
+		var answer string
+		if _, err := fmt.Fscanln(in, &answer); err == io.EOF {
+			// no more input, like piped stdin
+			fmt.Fprintln(out)
+			if current < 0 {
+				return fmt.Errorf("no default blog selected: %w", err)
+			}
+			break
+		}
+		if answer == "" && current >= 0 {
+			break
+		}
+
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(info.Blogs) {
+			current = n - 1
+			break
+		}
+	}
+
+	config.DefaultBlog = info.Blogs[current].Name
+	log.Println("default blog is", config.DefaultBlog)
+	return config.Save()
+}
+
+// PrintStatus writes whether the access token works and which account it belongs to.
+// It returns error if the token is rejected.
+func (config *InitConfig) PrintStatus(w io.Writer) error {
+	if path, err := config.configPath(); err == nil {
+		fmt.Fprintln(w, "config:", path)
+	}
+	fmt.Fprintln(w, "profile:", config.profile())
+	fmt.Fprintln(w, "client id:", config.ClientID)
+	if config.DefaultBlog != "" {
+		fmt.Fprintln(w, "default blog:", config.DefaultBlog)
+	}
+
+	if config.AccessToken == "" {
+		fmt.Fprintln(w, "token: none")
+		return ErrInvalidToken
+	}
+
+	info, err := config.client().ValidateToken()
+	if err != nil {
+		fmt.Fprintln(w, "token: rejected")
+		return err
+	}
+
+	fmt.Fprintln(w, "token: valid")
+	fmt.Fprintln(w, "account:", info.ID, "("+info.UserID+")")
+	return nil
+}
@@ -1,30 +1,120 @@
 package story
 
 import (
-	"bytes"
-	"errors"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"io"
-	"net/http"
-	"net/url"
+	"os"
+	"text/tabwriter"
 )
 
+//	"item":{
+//		"id":"blogtest_080@hanmail.net",
+//		"userId":"12345",
+//		"blogs":[{
+//			"name":"oauth-test",
+//			"url":"http://oauth-test.tistory.com",
+//			"secondaryUrl":"",
+//			"nickname":"Tistory API",
+//			"title":"나만의 앱, Tistory OAuth API 로 만들어보세요!",
+//			"description":"",
+//			"default":"Y",
+//			"role":"소유자",
+//			"blogId":"123",
+//			"statistics":{
+//				"post":"182",
+//				"comment":"146",
+//				"trackback":"0",
+//				"guestbook":"39",
+//				"invitation":"0"
+//			}
+//		}]
+//	}
+type BlogInfo struct {
+	ID     string `json:"id"`
+	UserID string `json:"userId"`
+	Blogs  []Blog `json:"blogs"`
+}
+
+type Blog struct {
+	Name         string         `json:"name"`
+	URL          string         `json:"url"`
+	SecondaryURL string         `json:"secondaryUrl"`
+	Nickname     string         `json:"nickname"`
+	Title        string         `json:"title"`
+	Description  string         `json:"description"`
+	Default      string         `json:"default"`
+	Role         string         `json:"role"`
+	BlogID       string         `json:"blogId"`
+	Statistics   BlogStatistics `json:"statistics"`
+}
+
+type BlogStatistics struct {
+	Post       int `json:"post,string"`
+	Comment    int `json:"comment,string"`
+	Trackback  int `json:"trackback,string"`
+	Guestbook  int `json:"guestbook,string"`
+	Invitation int `json:"invitation,string"`
+}
+
+// DefaultBlog returns the blog marked as default, or nil if there is none.
+func (info *BlogInfo) DefaultBlog() *Blog {
+	for i := range info.Blogs {
+		if info.Blogs[i].Default == "Y" {
+			return &info.Blogs[i]
+		}
+	}
+
+	return nil
+}
+
 // Info gets information about blog.
-func Info(accessToken string) (string, error) {
-	query := url.Values{}
-	query.Add("access_token", accessToken)
-	query.Add("output", "json")
-
-	resp, err := http.Get("https://www.tistory.com/apis/blog/info?" + query.Encode())
-	if err != nil {
-		return "", err
+func Info(accessToken string) (*BlogInfo, error) {
+	return NewClient(accessToken, "").BlogInfo()
+}
+
+type InfoConfig struct {
+	JSON bool
+	// Retry is retry policy of api requests, DefaultRetryPolicy if nil.
+	Retry *RetryPolicy
+}
+
+func (c *InfoConfig) Parse(args []string) error {
+	flag := flag.NewFlagSet("story info", flag.ExitOnError)
+	flag.BoolVar(&c.JSON, "json", false, "print blog information as json")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "story info [options]")
+		flag.PrintDefaults()
+	}
+
+	return flag.Parse(args)
+}
+
+// Do gets information about the user and the user's blogs.
+func (config *InfoConfig) Do(accessToken string) (*BlogInfo, error) {
+	return newClient(accessToken, "", config.Retry).BlogInfo()
+}
+
+// Print writes info as a table, or json if config.JSON is set.
+func (config *InfoConfig) Print(w io.Writer, info *BlogInfo) error {
+	if config.JSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	fmt.Fprintln(w, "user:", info.ID, "("+info.UserID+")")
+	if blog := info.DefaultBlog(); blog != nil {
+		fmt.Fprintln(w, "default blog:", blog.Name)
 	}
-	defer resp.Body.Close()
+	fmt.Fprintln(w)
 
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.New(parseError(resp.Body))
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tURL\tTITLE\tDESCRIPTION\tPOSTS\tCOMMENTS\tROLE")
+	for _, blog := range info.Blogs {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", blog.Name, blog.URL, blog.Title, blog.Description, blog.Statistics.Post, blog.Statistics.Comment, blog.Role)
 	}
 
-	var buffer bytes.Buffer
-	_, err = io.Copy(&buffer, resp.Body)
-	return buffer.String(), err
+	return table.Flush()
 }
@@ -0,0 +1,64 @@
+package story
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Browser opens authorization url for user.
+type Browser interface {
+	Open(url string) error
+}
+
+// BrowserFunc adapts a function to Browser.
+type BrowserFunc func(url string) error
+
+func (f BrowserFunc) Open(url string) error {
+	return f(url)
+}
+
+// SystemBrowser opens url with $BROWSER, or the platform default browser.
+type SystemBrowser struct{}
+
+func (SystemBrowser) Open(url string) error {
+	return browserCommand(url).Start()
+}
+
+func browserCommand(url string) *exec.Cmd {
+	// $BROWSER is a list of commands separated by colon, use the first one.
+	if browser := strings.Split(os.Getenv("BROWSER"), string(os.PathListSeparator))[0]; browser != "" {
+		args := strings.Fields(browser)
+		if strings.Contains(browser, "%s") {
+			for i := range args {
+				args[i] = strings.Replace(args[i], "%s", url, -1)
+			}
+		} else {
+			args = append(args, url)
+		}
+
+		return exec.Command(args[0], args[1:]...)
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		return exec.Command("open", url)
+	default:
+		return exec.Command("xdg-open", url)
+	}
+}
+
+// PrintBrowser does not open browser, but prints url to let user open it.
+type PrintBrowser struct {
+	Out io.Writer
+}
+
+func (b PrintBrowser) Open(url string) error {
+	_, err := fmt.Fprintf(b.Out, "Open the following url in your browser to authorize:\n\n    %s\n\n", url)
+	return err
+}
@@ -0,0 +1,254 @@
+package story
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// CacheEntry is an uploaded file, to be reused instead of uploading the same content again.
+type CacheEntry struct {
+	BlogName string
+	Hash     string
+	Name     string
+	URL      string
+	Replacer string
+	Uploaded time.Time
+	LastUsed time.Time
+}
+
+// UploadCache maps content hash of uploaded files to their attachments, per blog.
+type UploadCache struct {
+	Path    string
+	Entries map[string]*CacheEntry
+
+	mutex sync.Mutex
+	dirty bool
+}
+
+// DefaultCachePath returns $STORY_CACHE, or uploads.json in user cache directory.
+func DefaultCachePath() (string, error) {
+	if path := os.Getenv("STORY_CACHE"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "story", "uploads.json"), nil
+}
+
+// OpenUploadCache reads cache file of path, or DefaultCachePath if empty.
+// It returns empty cache if the file does not exist.
+func OpenUploadCache(path string) (*UploadCache, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultCachePath(); err != nil {
+			return nil, err
+		}
+	}
+
+	cache := UploadCache{Path: path, Entries: make(map[string]*CacheEntry)}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []*CacheEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, entry := range entries {
+		cache.Entries[cacheKey(entry.BlogName, entry.Hash)] = entry
+	}
+
+	return &cache, nil
+}
+
+// ContentHash returns hash of content used as cache key.
+func ContentHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+func cacheKey(blogName string, hash string) string {
+	return blogName + "/" + hash
+}
+
+// Get returns attachment uploaded to the blog with the same content hash.
+func (c *UploadCache) Get(blogName string, hash string) (*Attachment, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.Entries[cacheKey(blogName, hash)]
+	if !ok {
+		return nil, false
+	}
+
+	entry.LastUsed = time.Now()
+	c.dirty = true
+	return &Attachment{URL: entry.URL, Replacer: entry.Replacer}, true
+}
+
+// Put adds attachment uploaded to the blog.
+func (c *UploadCache) Put(blogName string, hash string, name string, attachment *Attachment) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	c.Entries[cacheKey(blogName, hash)] = &CacheEntry{
+		BlogName: blogName,
+		Hash:     hash,
+		Name:     name,
+		URL:      attachment.URL,
+		Replacer: attachment.Replacer,
+		Uploaded: now,
+		LastUsed: now,
+	}
+	c.dirty = true
+}
+
+// List returns entries of the blog, or every entry if blogName is empty,
+// sorted by last use.
+func (c *UploadCache) List(blogName string) []*CacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var entries []*CacheEntry
+	for _, entry := range c.Entries {
+		if blogName == "" || entry.BlogName == blogName {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries
+}
+
+// Prune removes entries of the blog, or every blog if blogName is empty,
+// which are not used since before. It returns the number of removed entries.
+func (c *UploadCache) Prune(blogName string, before time.Time) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	removed := 0
+	for key, entry := range c.Entries {
+		if (blogName == "" || entry.BlogName == blogName) && entry.LastUsed.Before(before) {
+			delete(c.Entries, key)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		c.dirty = true
+	}
+	return removed
+}
+
+// Save writes cache file if changed.
+func (c *UploadCache) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	entries := make([]*CacheEntry, 0, len(c.Entries))
+	for _, entry := range c.Entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Uploaded.Before(entries[j].Uploaded)
+	})
+
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(c.Path, content, 0600); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+type CacheConfig struct {
+	Command   string
+	Path      string
+	BlogName  string
+	OlderThan time.Duration
+	All       bool
+}
+
+func (c *CacheConfig) Parse(args []string) error {
+	if len(args) == 0 || (args[0] != "ls" && args[0] != "prune") {
+		fmt.Fprintln(os.Stderr, "Usage: story cache ls|prune [options]")
+		return errors.New("missing cache command")
+	}
+	c.Command = args[0]
+
+	flag := flag.NewFlagSet("story cache "+c.Command, flag.ExitOnError)
+	flag.StringVar(&c.Path, "cache", "", "upload cache file, $STORY_CACHE or uploads.json in user cache directory by default")
+	flag.StringVar(&c.BlogName, "blog", "", "if specified, only files uploaded to the blog")
+	if c.Command == "prune" {
+		flag.DurationVar(&c.OlderThan, "older-than", 30*24*time.Hour, "remove files not used for the duration")
+		flag.BoolVar(&c.All, "all", false, "remove every file")
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: story cache %s [options]\n", c.Command)
+		flag.PrintDefaults()
+	}
+
+	return flag.Parse(args[1:])
+}
+
+// Do lists or prunes upload cache.
+func (config *CacheConfig) Do(w io.Writer) error {
+	cache, err := OpenUploadCache(config.Path)
+	if err != nil {
+		return err
+	}
+
+	if config.Command == "prune" {
+		before := time.Now().Add(-config.OlderThan)
+		if config.All {
+			before = time.Now().Add(time.Hour)
+		}
+
+		removed := cache.Prune(config.BlogName, before)
+		fmt.Fprintf(w, "removed %d file(s)\n", removed)
+		return cache.Save()
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "BLOG\tHASH\tNAME\tLAST USED\tURL")
+	for _, entry := range cache.List(config.BlogName) {
+		fmt.Fprintf(table, "%s\t%.12s\t%s\t%s\t%s\n", entry.BlogName, entry.Hash, entry.Name, entry.LastUsed.Format("2006-01-02 15:04"), entry.URL)
+	}
+
+	return table.Flush()
+}
@@ -0,0 +1,147 @@
+package story
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+//	"categories":[{
+//		"id":"403929",
+//		"name":"OAuth2.0 Athentification",
+//		"parent":"",
+//		"label":"OAuth2.0 Athentification",
+//		"entries":"0"
+//	}]
+type Category struct {
+	ID      int    `json:"id,string"`
+	Name    string `json:"name"`
+	Parent  string `json:"parent"`
+	Label   string `json:"label"`
+	Entries int    `json:"entries,string"`
+}
+
+// Categories gets every category of the blog.
+func (c *Client) Categories() ([]Category, error) {
+	var respBody struct {
+		Tistory struct {
+			Item struct {
+				Categories []Category `json:"categories"`
+			} `json:"item"`
+		} `json:"tistory"`
+	}
+	if err := c.get("category/list", url.Values{}, &respBody); err != nil {
+		return nil, err
+	}
+
+	return respBody.Tistory.Item.Categories, nil
+}
+
+// CategoryID resolves category id, label (ex> Dev/Go) or name into category id.
+func (c *Client) CategoryID(category string) (int, error) {
+	if id, err := strconv.Atoi(category); err == nil {
+		return id, nil
+	}
+
+	categories, err := c.Categories()
+	if err != nil {
+		return 0, err
+	}
+
+	return FindCategory(categories, category)
+}
+
+// FindCategory finds category of label, or name if no label matches.
+func FindCategory(categories []Category, category string) (int, error) {
+	for _, item := range categories {
+		if item.Label == category {
+			return item.ID, nil
+		}
+	}
+
+	var found []Category
+	for _, item := range categories {
+		if item.Name == category {
+			found = append(found, item)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return 0, errors.New("category not found: " + category)
+	case 1:
+		return found[0].ID, nil
+	}
+
+	labels := make([]string, len(found))
+	for i := range found {
+		labels[i] = found[i].Label
+	}
+	return 0, fmt.Errorf("ambiguous category %s, use one of: %s", category, strings.Join(labels, ", "))
+}
+
+type CategoriesConfig struct {
+	BlogName string
+	JSON     bool
+	// Retry is retry policy of api requests, DefaultRetryPolicy if nil.
+	Retry *RetryPolicy
+}
+
+func (c *CategoriesConfig) Parse(args []string) error {
+	flag := flag.NewFlagSet("story categories", flag.ExitOnError)
+	flag.StringVar(&c.BlogName, "blog", c.BlogName, "tistory blog name, ex> {blog}.tistory.com")
+	flag.BoolVar(&c.JSON, "json", false, "print categories as json")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "story categories -blog=[blog id] [options]")
+		flag.PrintDefaults()
+	}
+
+	if err := flag.Parse(args); err != nil {
+		return err
+	}
+
+	if c.BlogName == "" {
+		return errMissingBlogName
+	}
+
+	return nil
+}
+
+func (config *CategoriesConfig) Do(accessToken string) ([]Category, error) {
+	return newClient(accessToken, config.BlogName, config.Retry).Categories()
+}
+
+// Print writes categories as a tree, or json if config.JSON is set.
+func (config *CategoriesConfig) Print(w io.Writer, categories []Category) error {
+	if config.JSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(categories)
+	}
+
+	children := make(map[string][]Category)
+	for _, item := range categories {
+		children[item.Parent] = append(children[item.Parent], item)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tPARENT\tNAME\tLABEL\tPOSTS")
+
+	var printTree func(parent string, depth int)
+	printTree = func(parent string, depth int) {
+		for _, item := range children[parent] {
+			fmt.Fprintf(table, "%d\t%s\t%s%s\t%s\t%d\n", item.ID, item.Parent, strings.Repeat("  ", depth), item.Name, item.Label, item.Entries)
+			printTree(strconv.Itoa(item.ID), depth+1)
+		}
+	}
+	printTree("", 0)
+
+	return table.Flush()
+}
@@ -0,0 +1,372 @@
+package story
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the root of tistory open API.
+const DefaultBaseURL = "https://www.tistory.com/apis"
+
+// Client calls tistory open API on behalf of a blog.
+type Client struct {
+	BaseURL     string
+	AccessToken string
+	BlogName    string
+	HTTPClient  *http.Client
+	Retry       RetryPolicy
+}
+
+// NewClient returns a client for the given blog using default http client
+// and DefaultRetryPolicy.
+func NewClient(accessToken string, blogName string) *Client {
+	return &Client{
+		BaseURL:     DefaultBaseURL,
+		AccessToken: accessToken,
+		BlogName:    blogName,
+		HTTPClient:  http.DefaultClient,
+		Retry:       DefaultRetryPolicy(),
+	}
+}
+
+// newClient returns NewClient with retry policy, or DefaultRetryPolicy if nil.
+func newClient(accessToken string, blogName string, retry *RetryPolicy) *Client {
+	client := NewClient(accessToken, blogName)
+	if retry != nil {
+		client.Retry = *retry
+	}
+
+	return client
+}
+
+// Visibility of a post as post/write and post/modify expect.
+type Visibility int
+
+const (
+	VisibilityPrivate   Visibility = 0
+	VisibilityProtected Visibility = 1
+	VisibilityPublic    Visibility = 3
+)
+
+// ParseVisibility parses visibility name (private, protected, public) or number.
+func ParseVisibility(s string) (Visibility, error) {
+	switch strings.ToLower(s) {
+	case "private", "0":
+		return VisibilityPrivate, nil
+	case "protected", "1":
+		return VisibilityProtected, nil
+	case "public", "3":
+		return VisibilityPublic, nil
+	}
+
+	return 0, errors.New("invalid visibility: " + s)
+}
+
+func (v Visibility) String() string {
+	switch v {
+	case VisibilityPrivate:
+		return "private"
+	case VisibilityProtected:
+		return "protected"
+	case VisibilityPublic:
+		return "public"
+	}
+
+	return strconv.Itoa(int(v))
+}
+
+// PostParams holds parameters of post/write and post/modify.
+// Zero values are left to tistory defaults.
+type PostParams struct {
+	PostID        string
+	Title         string
+	Content       string
+	Visibility    Visibility
+	CategoryID    int
+	Published     time.Time
+	Slogan        string
+	Tags          []string
+	AcceptComment *bool
+	Password      string
+}
+
+func (p *PostParams) values() url.Values {
+	query := url.Values{}
+	if p.PostID != "" {
+		query.Add("postId", p.PostID)
+	}
+	query.Add("title", p.Title)
+	query.Add("content", p.Content)
+	query.Add("visibility", strconv.Itoa(int(p.Visibility)))
+	if p.CategoryID != 0 {
+		query.Add("category", strconv.Itoa(p.CategoryID))
+	}
+	if !p.Published.IsZero() {
+		query.Add("published", strconv.FormatInt(p.Published.Unix(), 10))
+	}
+	if p.Slogan != "" {
+		query.Add("slogan", p.Slogan)
+	}
+	if len(p.Tags) > 0 {
+		query.Add("tag", strings.Join(p.Tags, ","))
+	}
+	if p.AcceptComment != nil {
+		if *p.AcceptComment {
+			query.Add("acceptComment", "1")
+		} else {
+			query.Add("acceptComment", "0")
+		}
+	}
+	if p.Password != "" {
+		query.Add("password", p.Password)
+	}
+	return query
+}
+
+// Attachment is the result of post/attach.
+type Attachment struct {
+	URL      string `json:"url"`
+	Replacer string `json:"replacer"`
+}
+
+// BlogInfo gets information about the user and the user's blogs.
+func (c *Client) BlogInfo() (*BlogInfo, error) {
+	var respBody struct {
+		Tistory struct {
+			Item BlogInfo `json:"item"`
+		} `json:"tistory"`
+	}
+	if err := c.get("blog/info", url.Values{}, &respBody); err != nil {
+		return nil, err
+	}
+
+	return &respBody.Tistory.Item, nil
+}
+
+// ValidateToken checks if the access token is accepted, and returns
+// information of the account which the token belongs to.
+func (c *Client) ValidateToken() (*BlogInfo, error) {
+	return c.BlogInfo()
+}
+
+// ReadPost reads a single post of the blog.
+func (c *Client) ReadPost(postID string) (*TistoryPost, error) {
+	query := url.Values{}
+	query.Add("postId", postID)
+
+	var post struct {
+		Tistory struct {
+			Item TistoryPost `json:"item"`
+		} `json:"tistory"`
+	}
+	if err := c.get("post/read", query, &post); err != nil {
+		return nil, err
+	}
+
+	return &post.Tistory.Item, nil
+}
+
+// PostList is a page of post/list.
+type PostList struct {
+	URL        string        `json:"url"`
+	Page       int           `json:"page,string"`
+	Count      int           `json:"count,string"`
+	TotalCount int           `json:"totalCount,string"`
+	Posts      []TistoryPost `json:"posts"`
+}
+
+// ListPosts reads a page of posts of the blog. page starts from 1.
+func (c *Client) ListPosts(page int) (*PostList, error) {
+	query := url.Values{}
+	query.Add("page", strconv.Itoa(page))
+
+	var respBody struct {
+		Tistory struct {
+			Item PostList `json:"item"`
+		} `json:"tistory"`
+	}
+	if err := c.get("post/list", query, &respBody); err != nil {
+		return nil, err
+	}
+
+	return &respBody.Tistory.Item, nil
+}
+
+// ListAllPosts follows pages of post/list and returns every post of the blog.
+func (c *Client) ListAllPosts() ([]TistoryPost, error) {
+	var posts []TistoryPost
+	for page := 1; ; page++ {
+		list, err := c.ListPosts(page)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, list.Posts...)
+		if len(list.Posts) == 0 || len(posts) >= list.TotalCount {
+			return posts, nil
+		}
+	}
+}
+
+// WritePost creates a new post and returns its url.
+func (c *Client) WritePost(params *PostParams) (string, error) {
+	return c.writePost("post/write", params)
+}
+
+// ModifyPost updates the post of params.PostID and returns its url.
+func (c *Client) ModifyPost(params *PostParams) (string, error) {
+	if params.PostID == "" {
+		return "", errors.New("missing post id")
+	}
+
+	return c.writePost("post/modify", params)
+}
+
+func (c *Client) writePost(api string, params *PostParams) (string, error) {
+	var respBody struct {
+		Tistory struct {
+			URL string `json:"url"`
+		} `json:"tistory"`
+	}
+	if err := c.postForm(api, params.values(), &respBody); err != nil {
+		return "", err
+	}
+
+	return respBody.Tistory.URL, nil
+}
+
+// AttachFile uploads a file to the blog.
+func (c *Client) AttachFile(filename string, r io.Reader) (*Attachment, error) {
+	var payloadForm bytes.Buffer
+	mpWriter := multipart.NewWriter(&payloadForm)
+	for key, values := range c.query(url.Values{}) {
+		for _, value := range values {
+			if err := mpWriter.WriteField(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fileWriter, err := mpWriter.CreateFormFile("uploadedfile", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(fileWriter, r); err != nil {
+		return nil, err
+	}
+
+	// flush body content
+	if err := mpWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("post/attach"), &payloadForm)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mpWriter.FormDataContentType())
+
+	var respBody struct {
+		Tistory Attachment `json:"tistory"`
+	}
+	if err := c.do("post/attach", req, &respBody); err != nil {
+		return nil, err
+	}
+
+	return &respBody.Tistory, nil
+}
+
+func (c *Client) endpoint(api string) string {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + api
+}
+
+// query appends common parameters to query.
+func (c *Client) query(query url.Values) url.Values {
+	query.Set("access_token", c.AccessToken)
+	query.Set("output", "json")
+	if c.BlogName != "" {
+		query.Set("blogName", c.BlogName)
+	}
+
+	return query
+}
+
+func (c *Client) get(api string, query url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint(api)+"?"+c.query(query).Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(api, req, v)
+}
+
+func (c *Client) postForm(api string, query url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, c.endpoint(api), strings.NewReader(c.query(query).Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(api, req, v)
+}
+
+func (c *Client) do(api string, req *http.Request, v interface{}) error {
+	for attempt := 1; ; attempt++ {
+		err := c.doOnce(api, req, v)
+		if err == nil || attempt >= c.Retry.MaxAttempts || !c.Retry.retryable(api, req, err) {
+			return err
+		}
+
+		delay := c.Retry.delay(attempt, err)
+		log.Printf("%v, retrying in %v (%d/%d)", err, delay.Round(time.Millisecond), attempt, c.Retry.MaxAttempts-1)
+		time.Sleep(delay)
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) doOnce(api string, req *http.Request, v interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		apiErr := newAPIError(api, resp.StatusCode, body)
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return apiErr
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
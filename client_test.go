@@ -0,0 +1,431 @@
+package story
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of a fake server serving handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewClient("token", "myblog")
+	client.BaseURL = server.URL
+	client.Retry = RetryPolicy{MaxAttempts: 1}
+	return client
+}
+
+func TestReadPost(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/post/read" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		query := r.URL.Query()
+		for key, want := range map[string]string{"access_token": "token", "output": "json", "blogName": "myblog", "postId": "12"} {
+			if got := query.Get(key); got != want {
+				t.Errorf("%s = %q, want %q", key, got, want)
+			}
+		}
+
+		fmt.Fprint(w, `{"tistory":{"status":"200","item":{"id":"12","title":"Hello","content":"<p>hi</p>","categoryId":"3","visibility":"20","tags":{"tag":["go","tistory"]}}}}`)
+	})
+
+	post, err := client.ReadPost("12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.ID != "12" || post.Title != "Hello" || post.CategoryID != 3 || post.Visibility != 20 {
+		t.Errorf("unexpected post %+v", post)
+	}
+	if want := (TistoryTags{"go", "tistory"}); !reflect.DeepEqual(post.Tags, want) {
+		t.Errorf("tags = %v, want %v", post.Tags, want)
+	}
+}
+
+func TestWritePost(t *testing.T) {
+	acceptComment := false
+	params := PostParams{
+		Title:         "Hello",
+		Content:       "<p>hi</p>",
+		Visibility:    VisibilityPublic,
+		CategoryID:    3,
+		Published:     time.Unix(1700000000, 0),
+		Tags:          []string{"go", "tistory"},
+		AcceptComment: &acceptComment,
+	}
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/post/write" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]string{
+			"access_token":  "token",
+			"blogName":      "myblog",
+			"title":         "Hello",
+			"content":       "<p>hi</p>",
+			"visibility":    "3",
+			"category":      "3",
+			"published":     "1700000000",
+			"tag":           "go,tistory",
+			"acceptComment": "0",
+		}
+		for key, value := range want {
+			if got := r.PostForm.Get(key); got != value {
+				t.Errorf("%s = %q, want %q", key, got, value)
+			}
+		}
+		if _, ok := r.PostForm["postId"]; ok {
+			t.Error("postId should not be sent for a new post")
+		}
+
+		fmt.Fprint(w, `{"tistory":{"status":"200","postId":"13","url":"https://myblog.tistory.com/13"}}`)
+	})
+
+	url, err := client.WritePost(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://myblog.tistory.com/13" {
+		t.Errorf("url = %q", url)
+	}
+}
+
+func TestModifyPostWithoutID(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request expected")
+	})
+
+	if _, err := client.ModifyPost(&PostParams{Title: "Hello"}); err == nil {
+		t.Error("expected error of missing post id")
+	}
+}
+
+func TestAttachFile(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/post/attach" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+
+		for key, want := range map[string]string{"access_token": "token", "output": "json", "blogName": "myblog"} {
+			if got := r.FormValue(key); got != want {
+				t.Errorf("%s = %q, want %q", key, got, want)
+			}
+		}
+
+		file, header, err := r.FormFile("uploadedfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		content, _ := io.ReadAll(file)
+		if header.Filename != "a.png" || string(content) != "image content" {
+			t.Errorf("uploaded %q with %q", header.Filename, content)
+		}
+
+		fmt.Fprint(w, `{"tistory":{"status":"200","url":"https://cfile.tistory.com/a.png","replacer":"[##_1N|a.png_##]"}}`)
+	})
+
+	attachment, err := client.AttachFile("a.png", strings.NewReader("image content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Attachment{URL: "https://cfile.tistory.com/a.png", Replacer: "[##_1N|a.png_##]"}
+	if *attachment != want {
+		t.Errorf("attachment = %+v, want %+v", *attachment, want)
+	}
+}
+
+func TestListAllPosts(t *testing.T) {
+	pages := map[string]string{
+		"1": `{"tistory":{"status":"200","item":{"page":"1","count":"2","totalCount":"5","posts":[{"id":"5"},{"id":"4"}]}}}`,
+		"2": `{"tistory":{"status":"200","item":{"page":"2","count":"2","totalCount":"5","posts":[{"id":"3"},{"id":"2"}]}}}`,
+		"3": `{"tistory":{"status":"200","item":{"page":"3","count":"1","totalCount":"5","posts":[{"id":"1"}]}}}`,
+	}
+
+	var requested []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		requested = append(requested, page)
+
+		body, ok := pages[page]
+		if !ok {
+			t.Errorf("unexpected page %q", page)
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	})
+
+	posts, err := client.ListAllPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+
+	if want := []string{"5", "4", "3", "2", "1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("posts = %v, want %v", ids, want)
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("requested pages %v, want %v", requested, want)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		body         string
+		status       string
+		message      string
+		unauthorized bool
+		notFound     bool
+	}{
+		{
+			name:       "not found",
+			statusCode: http.StatusNotFound,
+			body:       `{"tistory":{"status":"404","error_message":"no such post"}}`,
+			status:     "404",
+			message:    "no such post",
+			notFound:   true,
+		},
+		{
+			name:         "unauthorized",
+			statusCode:   http.StatusUnauthorized,
+			body:         `{"tistory":{"status":"401","error_message":"invalid access token"}}`,
+			status:       "401",
+			message:      "invalid access token",
+			unauthorized: true,
+		},
+		{
+			name:         "tistory status in bad request",
+			statusCode:   http.StatusBadRequest,
+			body:         `{"tistory":{"status":"401","error_message":"expired"}}`,
+			status:       "401",
+			message:      "expired",
+			unauthorized: true,
+		},
+		{
+			name:       "not json",
+			statusCode: http.StatusInternalServerError,
+			body:       `<html>oops</html>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statusCode)
+				fmt.Fprint(w, test.body)
+			})
+
+			_, err := client.ReadPost("1")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected APIError, got %v", err)
+			}
+
+			if apiErr.StatusCode != test.statusCode || apiErr.Status != test.status || apiErr.Message != test.message {
+				t.Errorf("unexpected error %+v", apiErr)
+			}
+			if apiErr.Endpoint != "post/read" || string(apiErr.Body) != test.body {
+				t.Errorf("endpoint %q, body %q", apiErr.Endpoint, apiErr.Body)
+			}
+			if test.message != "" && !strings.Contains(err.Error(), test.message) {
+				t.Errorf("%q does not contain message", err.Error())
+			}
+
+			if got := IsUnauthorized(err); got != test.unauthorized {
+				t.Errorf("IsUnauthorized = %v", got)
+			}
+			if got := errors.Is(err, ErrInvalidToken); got != test.unauthorized {
+				t.Errorf("errors.Is(err, ErrInvalidToken) = %v", got)
+			}
+			if got := IsNotFound(err); got != test.notFound {
+				t.Errorf("IsNotFound = %v", got)
+			}
+		})
+	}
+}
+
+// failingHandler fails the first failures requests with status, then serves body.
+func failingHandler(t *testing.T, attempts *int, failures int, status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*attempts++
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Method == http.MethodPost && r.PostForm.Get("title") != "Hello" {
+			t.Errorf("attempt %d: form is not sent again: %v", *attempts, r.PostForm)
+		}
+
+		if *attempts <= failures {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"tistory":{"status":"`+fmt.Sprint(status)+`","error_message":"try again"}}`)
+			return
+		}
+		fmt.Fprint(w, body)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	const postBody = `{"tistory":{"status":"200","url":"https://myblog.tistory.com/1"}}`
+
+	tests := []struct {
+		name     string
+		call     func(client *Client) error
+		status   int
+		unsafe   bool
+		attempts int
+		fail     bool
+	}{
+		{
+			name:     "read on 503",
+			call:     func(client *Client) error { _, err := client.ReadPost("1"); return err },
+			status:   http.StatusServiceUnavailable,
+			attempts: 3,
+		},
+		{
+			name:     "read on 429",
+			call:     func(client *Client) error { _, err := client.ReadPost("1"); return err },
+			status:   http.StatusTooManyRequests,
+			attempts: 3,
+		},
+		{
+			name:     "no retry on 400",
+			call:     func(client *Client) error { _, err := client.ReadPost("1"); return err },
+			status:   http.StatusBadRequest,
+			attempts: 1,
+			fail:     true,
+		},
+		{
+			name: "modify on 502",
+			call: func(client *Client) error {
+				_, err := client.ModifyPost(&PostParams{PostID: "1", Title: "Hello"})
+				return err
+			},
+			status:   http.StatusBadGateway,
+			attempts: 3,
+		},
+		{
+			name:     "no retry of write",
+			call:     func(client *Client) error { _, err := client.WritePost(&PostParams{Title: "Hello"}); return err },
+			status:   http.StatusServiceUnavailable,
+			attempts: 1,
+			fail:     true,
+		},
+		{
+			name:     "write with RetryUnsafe",
+			call:     func(client *Client) error { _, err := client.WritePost(&PostParams{Title: "Hello"}); return err },
+			status:   http.StatusServiceUnavailable,
+			unsafe:   true,
+			attempts: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts int
+			client := newTestClient(t, failingHandler(t, &attempts, 2, test.status, postBody))
+			client.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, RetryUnsafe: test.unsafe}
+
+			err := test.call(client)
+			if (err != nil) != test.fail {
+				t.Errorf("err = %v", err)
+			}
+			if attempts != test.attempts {
+				t.Errorf("attempts = %d, want %d", attempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var attempts int
+	client := newTestClient(t, failingHandler(t, &attempts, 10, http.StatusInternalServerError, ""))
+	// Retry-After of 1s is capped by MaxDelay
+	client.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	start := time.Now()
+	_, err := client.ReadPost("1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || apiErr.RetryAfter != time.Second {
+		t.Errorf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, Retry-After is not capped", elapsed)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{"first retry", 1, errors.New("reset"), 50 * time.Millisecond, 100 * time.Millisecond},
+		{"third retry", 3, errors.New("reset"), 200 * time.Millisecond, 400 * time.Millisecond},
+		{"capped", 10, errors.New("reset"), 2500 * time.Millisecond, 5 * time.Second},
+		{"retry after", 1, &APIError{RetryAfter: 2 * time.Second}, 2 * time.Second, 2 * time.Second},
+		{"retry after capped", 1, &APIError{RetryAfter: time.Minute}, 5 * time.Second, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delay := policy.delay(test.attempt, test.err)
+			if delay < test.min || delay > test.max {
+				t.Errorf("delay = %v, want in [%v, %v]", delay, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("seconds: %v", got)
+	}
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 58*time.Second || got > time.Minute {
+		t.Errorf("date: %v", got)
+	}
+
+	for _, value := range []string{"", "soon"} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("%q: %v", value, got)
+		}
+	}
+}
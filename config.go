@@ -0,0 +1,199 @@
+package story
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// DefaultProfile is the profile used when no profile is given.
+const DefaultProfile = "default"
+
+// configFile is the content of config file, configs of each profile.
+type configFile struct {
+	Profiles map[string]json.RawMessage
+}
+
+// DefaultConfigPath returns $STORY_CONFIG, or config.json in user config directory.
+func DefaultConfigPath() (string, error) {
+	if path := os.Getenv("STORY_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "story", "config.json"), nil
+}
+
+// legacyConfigPath is where older versions saved config.
+func legacyConfigPath() string {
+	return filepath.Join(filepath.Dir(os.Args[0]), "story.conf")
+}
+
+func (c *InitConfig) configPath() (string, error) {
+	if c.Path != "" {
+		return c.Path, nil
+	}
+
+	return DefaultConfigPath()
+}
+
+func (c *InitConfig) profile() string {
+	if c.Profile != "" {
+		return c.Profile
+	}
+
+	if profile := os.Getenv("STORY_PROFILE"); profile != "" {
+		return profile
+	}
+
+	return DefaultProfile
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file configFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+
+	if file.Profiles == nil {
+		file.Profiles = make(map[string]json.RawMessage)
+	}
+
+	return &file, nil
+}
+
+// Load reads config of the profile.
+func (c *InitConfig) Load() error {
+	path, err := c.configPath()
+	if err != nil {
+		return err
+	}
+
+	file, err := readConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) && c.Path == "" {
+		return c.loadLegacy()
+	} else if err != nil {
+		return err
+	}
+
+	warnPermission(path)
+
+	profile, ok := file.Profiles[c.profile()]
+	if !ok {
+		return errors.New("profile not found: " + c.profile())
+	}
+
+	if err := json.Unmarshal(profile, c); err != nil {
+		return err
+	}
+
+	return c.loadSecrets()
+}
+
+// warnPermission warns if config file is readable by others.
+func warnPermission(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	if stat, err := os.Stat(path); err == nil && stat.Mode().Perm()&0077 != 0 {
+		log.Printf("warning: %s is accessible by other users, run `chmod 600 %s`", path, path)
+	}
+}
+
+// loadLegacy reads story.conf next to the executable, which has no profiles.
+func (c *InitConfig) loadLegacy() error {
+	if c.profile() != DefaultProfile {
+		return errors.New("profile not found: " + c.profile())
+	}
+
+	content, err := os.ReadFile(legacyConfigPath())
+	if err != nil {
+		return err
+	}
+
+	warnPermission(legacyConfigPath())
+	if err := json.Unmarshal(content, c); err != nil {
+		return err
+	}
+
+	return c.loadSecrets()
+}
+
+// Save writes config of the profile, keeping other profiles.
+func (c *InitConfig) Save() error {
+	path, err := c.configPath()
+	if err != nil {
+		return err
+	}
+
+	file, err := readConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		file = &configFile{Profiles: make(map[string]json.RawMessage)}
+	} else if err != nil {
+		return err
+	}
+
+	stored, err := c.storedConfig()
+	if err != nil {
+		return err
+	}
+
+	profile, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	file.Profiles[c.profile()] = profile
+
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return err
+	}
+
+	// WriteFile keeps permission of existing file
+	return os.Chmod(path, 0600)
+}
+
+// RetryPolicy returns DefaultRetryPolicy overridden by retry settings of the config.
+func (c *InitConfig) RetryPolicy() (RetryPolicy, error) {
+	policy := DefaultRetryPolicy()
+	if c.RetryAttempts > 0 {
+		policy.MaxAttempts = c.RetryAttempts
+	}
+
+	if c.RetryDelay != "" {
+		delay, err := time.ParseDuration(c.RetryDelay)
+		if err != nil {
+			return policy, fmt.Errorf("invalid RetryDelay: %v", err)
+		}
+		policy.BaseDelay = delay
+	}
+
+	if c.RetryUnsafe {
+		policy.RetryUnsafe = true
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,93 @@
+package story
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidToken is returned when tistory rejects the access token.
+// APIError of 401 matches it with errors.Is.
+var ErrInvalidToken = errors.New("access token is invalid or expired, run `story auth`")
+
+// APIError is an error response of tistory open API.
+type APIError struct {
+	// StatusCode is http status code of the response.
+	StatusCode int
+	// Status is tistory status code in the response body, "401" for example.
+	Status   string
+	Message  string
+	Endpoint string
+	Body     []byte
+	// RetryAfter is Retry-After header of the response, if any.
+	RetryAfter time.Duration
+}
+
+// newAPIError reads tistory error from response body, like
+// {"tistory":{"status":"401","error_message":"..."}}
+func newAPIError(endpoint string, statusCode int, body []byte) *APIError {
+	var responseBody struct {
+		Tistory struct {
+			Status       string `json:"status"`
+			ErrorMessage string `json:"error_message"`
+		} `json:"tistory"`
+	}
+
+	apiErr := APIError{StatusCode: statusCode, Endpoint: endpoint, Body: body}
+	if err := json.Unmarshal(body, &responseBody); err == nil {
+		apiErr.Status = responseBody.Tistory.Status
+		apiErr.Message = responseBody.Tistory.ErrorMessage
+	}
+
+	return &apiErr
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+
+	if e.Status == "" {
+		return fmt.Sprintf("%s: http %d: %s", e.Endpoint, e.StatusCode, message)
+	}
+
+	return fmt.Sprintf("%s: code %s: %s", e.Endpoint, e.Status, message)
+}
+
+// Is reports unauthorized error as ErrInvalidToken.
+func (e *APIError) Is(target error) bool {
+	return target == ErrInvalidToken && e.code() == http.StatusUnauthorized
+}
+
+// code returns tistory status code, or http status code if there is none.
+func (e *APIError) code() int {
+	if status, err := strconv.Atoi(e.Status); err == nil {
+		return status
+	}
+
+	return e.StatusCode
+}
+
+func hasAPIErrorCode(err error, code int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.code() == code || apiErr.StatusCode == code)
+}
+
+// IsUnauthorized reports whether err is caused by invalid or expired access token.
+func IsUnauthorized(err error) bool {
+	return hasAPIErrorCode(err, http.StatusUnauthorized)
+}
+
+// IsNotFound reports whether err is caused by missing blog, post or category.
+func IsNotFound(err error) bool {
+	return hasAPIErrorCode(err, http.StatusNotFound)
+}
+
+// IsRateLimited reports whether err is caused by too many requests.
+func IsRateLimited(err error) bool {
+	return hasAPIErrorCode(err, http.StatusTooManyRequests)
+}
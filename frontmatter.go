@@ -0,0 +1,187 @@
+package story
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is post metadata at the top of markdown file,
+// enclosed by "---" for yaml or "+++" for toml.
+//
+//	---
+//	title: Hello, world
+//	category: 12345
+//	tags: [go, tistory]
+//	visibility: public
+//	images:
+//	  maxWidth: 1600
+//	---
+type FrontMatter struct {
+	Title      string   `yaml:"title" toml:"title"`
+	Category   string   `yaml:"category" toml:"category"`
+	Tags       []string `yaml:"tags" toml:"tags"`
+	Visibility string   `yaml:"visibility" toml:"visibility"`
+	// Published is a future time in the format of -published flag,
+	// like "2006-01-02 15:04" in local time zone.
+	Published     string `yaml:"published" toml:"published"`
+	Slogan        string `yaml:"slogan" toml:"slogan"`
+	AcceptComment *bool  `yaml:"acceptComment" toml:"acceptComment"`
+	Password      string `yaml:"password" toml:"password"`
+
+	// Images preprocesses images of the post, unless options are given as flags.
+	Images *ImageOptions `yaml:"images" toml:"images"`
+	// Highlight styles code blocks of the post, unless options are given as flags.
+	Highlight *HighlightOptions `yaml:"highlight" toml:"highlight"`
+	// Math renders math of the post, unless options are given as flags.
+	Math *MathOptions `yaml:"math" toml:"math"`
+}
+
+// ParseFrontMatter splits front matter from markdown content.
+// If content has no front matter, it returns nil and content as is.
+// Leading "---" is taken as a horizontal rule, not front matter, unless it is
+// closed by another "---" and lines between them are a yaml mapping.
+func ParseFrontMatter(content []byte) (*FrontMatter, []byte, error) {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	delimiter := strings.TrimSpace(strings.TrimPrefix(string(lines[0]), "\ufeff"))
+	if delimiter != "---" && delimiter != "+++" {
+		return nil, content, nil
+	}
+
+	offset := len(lines[0])
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(string(line)) != delimiter {
+			offset += len(line)
+			continue
+		}
+
+		header := content[len(lines[0]):offset]
+		body := content[offset+len(line):]
+		if !isMapping(delimiter, header) {
+			return nil, content, nil
+		}
+
+		var matter FrontMatter
+		if delimiter == "---" {
+			if err := yaml.Unmarshal(header, &matter); err != nil {
+				return nil, nil, err
+			}
+		} else if err := unmarshalTOML(header, &matter); err != nil {
+			return nil, nil, err
+		}
+
+		if matter.Published != "" {
+			// fail before uploading images of the post
+			if _, err := parsePublished(matter.Published); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return &matter, body, nil
+	}
+
+	return nil, content, nil
+}
+
+// unmarshalTOML reads toml front matter, whose published may be a datetime
+// or an integer instead of a string.
+func unmarshalTOML(header []byte, matter *FrontMatter) error {
+	var document struct {
+		FrontMatter
+		Published interface{} `toml:"published"`
+	}
+	if err := toml.Unmarshal(header, &document); err != nil {
+		return err
+	}
+
+	*matter = document.FrontMatter
+	switch published := document.Published.(type) {
+	case nil:
+	case string:
+		matter.Published = published
+	case int64:
+		matter.Published = strconv.FormatInt(published, 10)
+	case time.Time:
+		switch published.Location().String() {
+		case "datetime-local", "date-local", "time-local":
+			// no offset, local time zone like -published
+			matter.Published = published.Format("2006-01-02T15:04:05")
+		default:
+			matter.Published = published.Format(time.RFC3339)
+		}
+	default:
+		return fmt.Errorf("invalid published: %v", published)
+	}
+
+	return nil
+}
+
+// isMapping reports whether header is a non-empty yaml mapping, or valid toml.
+func isMapping(delimiter string, header []byte) bool {
+	if delimiter == "+++" {
+		var table map[string]interface{}
+		return toml.Unmarshal(header, &table) == nil && len(table) > 0
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(header, &document); err != nil || len(document.Content) == 0 {
+		return false
+	}
+
+	return document.Content[0].Kind == yaml.MappingNode
+}
+
+// Apply sets fields of params which are specified in front matter.
+// client is used to resolve category name.
+func (matter *FrontMatter) Apply(client *Client, params *PostParams) error {
+	if matter.Title != "" {
+		params.Title = matter.Title
+	}
+
+	if matter.Category != "" {
+		id, err := client.CategoryID(matter.Category)
+		if err != nil {
+			return err
+		}
+		params.CategoryID = id
+	}
+
+	if len(matter.Tags) > 0 {
+		params.Tags = matter.Tags
+	}
+
+	if matter.Visibility != "" {
+		visibility, err := ParseVisibility(matter.Visibility)
+		if err != nil {
+			return err
+		}
+		params.Visibility = visibility
+	}
+
+	if matter.Published != "" {
+		published, err := parsePublished(matter.Published)
+		if err != nil {
+			return err
+		}
+		params.Published = published
+	}
+
+	if matter.Slogan != "" {
+		params.Slogan = matter.Slogan
+	}
+
+	if matter.AcceptComment != nil {
+		params.AcceptComment = matter.AcceptComment
+	}
+
+	if matter.Password != "" {
+		params.Password = matter.Password
+	}
+
+	return nil
+}
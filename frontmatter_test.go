@@ -0,0 +1,143 @@
+package story
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		matter  *FrontMatter
+		body    string
+		fail    bool
+	}{
+		{
+			name:    "yaml",
+			content: "---\ntitle: Hello\ntags: [go, tistory]\n---\n# body\n",
+			matter:  &FrontMatter{Title: "Hello", Tags: []string{"go", "tistory"}},
+			body:    "# body\n",
+		},
+		{
+			name:    "toml",
+			content: "+++\ntitle = \"Hello\"\ncategory = \"dev\"\n+++\nbody\n",
+			matter:  &FrontMatter{Title: "Hello", Category: "dev"},
+			body:    "body\n",
+		},
+		{
+			name:    "no front matter",
+			content: "# Hello\n",
+			body:    "# Hello\n",
+		},
+		{
+			name:    "leading rule",
+			content: "---\n\nStarts with a rule\n",
+			body:    "---\n\nStarts with a rule\n",
+		},
+		{
+			name:    "two rules",
+			content: "---\n\nBetween rules\n\n---\n\nafter\n",
+			body:    "---\n\nBetween rules\n\n---\n\nafter\n",
+		},
+		{
+			name:    "rules around a list",
+			content: "---\n- a\n- b\n---\n",
+			body:    "---\n- a\n- b\n---\n",
+		},
+		{
+			name:    "yaml published in flag format",
+			content: "---\npublished: 2030-05-01 10:00\n---\n",
+			matter:  &FrontMatter{Published: "2030-05-01 10:00"},
+			body:    "",
+		},
+		{
+			name:    "yaml published with offset",
+			content: "---\npublished: 2030-05-01T10:00:00+09:00\n---\n",
+			matter:  &FrontMatter{Published: "2030-05-01T10:00:00+09:00"},
+			body:    "",
+		},
+		{
+			name:    "toml local datetime",
+			content: "+++\npublished = 2030-05-01T10:00:00\n+++\n",
+			matter:  &FrontMatter{Published: "2030-05-01T10:00:00"},
+			body:    "",
+		},
+		{
+			name:    "toml offset datetime",
+			content: "+++\npublished = 2030-05-01T10:00:00+09:00\n+++\n",
+			matter:  &FrontMatter{Published: "2030-05-01T10:00:00+09:00"},
+			body:    "",
+		},
+		{
+			name:    "toml timestamp",
+			content: "+++\npublished = 1904000000\n+++\n",
+			matter:  &FrontMatter{Published: "1904000000"},
+			body:    "",
+		},
+		{
+			name:    "past published",
+			content: "---\npublished: 2020-05-01 10:00\n---\nbody\n",
+			fail:    true,
+		},
+		{
+			name:    "invalid published",
+			content: "---\npublished: next monday\n---\nbody\n",
+			fail:    true,
+		},
+		{
+			name:    "invalid field type",
+			content: "---\ntitle: [a, b]\n---\nbody\n",
+			fail:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matter, body, err := ParseFrontMatter([]byte(test.content))
+			if test.fail {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(matter, test.matter) {
+				t.Errorf("front matter = %+v, want %+v", matter, test.matter)
+			}
+			if string(body) != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+		})
+	}
+}
+
+func TestFrontMatterPublishedInLocalTime(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("KST", 9*60*60)
+	defer func() { time.Local = local }()
+
+	tests := []struct {
+		published string
+		want      time.Time
+	}{
+		{"2030-05-01 10:00", time.Date(2030, 5, 1, 10, 0, 0, 0, time.Local)},
+		{"2030-05-01T10:00:00", time.Date(2030, 5, 1, 10, 0, 0, 0, time.Local)},
+		{"2030-05-01T10:00:00Z", time.Date(2030, 5, 1, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		var params PostParams
+		matter := FrontMatter{Published: test.published}
+		if err := matter.Apply(nil, &params); err != nil {
+			t.Fatal(err)
+		}
+		if !params.Published.Equal(test.want) {
+			t.Errorf("%q: published = %v, want %v", test.published, params.Published, test.want)
+		}
+	}
+}
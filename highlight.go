@@ -0,0 +1,141 @@
+package story
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultHighlightStyle is the style of code blocks if not specified.
+const DefaultHighlightStyle = "github"
+
+// HighlightOptions controls syntax highlighting of fenced code blocks.
+type HighlightOptions struct {
+	// Style is a chroma style, like "github" or "monokai".
+	// "none" disables highlighting.
+	Style string `yaml:"style" toml:"style"`
+	// LineNumbers shows line numbers of code.
+	LineNumbers bool `yaml:"lineNumbers" toml:"lineNumbers"`
+	// Classes writes css classes and a stylesheet instead of inline styles.
+	Classes bool `yaml:"classes" toml:"classes"`
+}
+
+// merge returns options with fields not set filled from other.
+func (o HighlightOptions) merge(other *HighlightOptions) HighlightOptions {
+	if other == nil {
+		return o
+	}
+
+	if o.Style == "" {
+		o.Style = other.Style
+	}
+	o.LineNumbers = o.LineNumbers || other.LineNumbers
+	o.Classes = o.Classes || other.Classes
+
+	return o
+}
+
+func (o *HighlightOptions) addFlags(flag *flag.FlagSet) {
+	flag.StringVar(&o.Style, "highlight-style", "", fmt.Sprintf("style of code blocks, like monokai, %q by default, \"none\" to disable", DefaultHighlightStyle))
+	flag.BoolVar(&o.LineNumbers, "line-numbers", false, "show line numbers of code blocks")
+	flag.BoolVar(&o.Classes, "highlight-classes", false, "highlight code blocks with css classes and a stylesheet instead of inline styles")
+}
+
+// codeHighlighter renders fenced code blocks of known languages with chroma.
+// Other code blocks are rendered by html renderer.
+type codeHighlighter struct {
+	htmlFallback
+
+	formatter *chromahtml.Formatter
+	style     *chroma.Style
+	classes   bool
+	cssDone   bool
+}
+
+// newCodeHighlighter returns highlighter of options, or nil if highlighting is disabled.
+func newCodeHighlighter(options HighlightOptions) (*codeHighlighter, error) {
+	name := options.Style
+	if name == "" {
+		name = DefaultHighlightStyle
+	}
+	if strings.EqualFold(name, "none") {
+		return nil, nil
+	}
+
+	style, ok := styles.Registry[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown highlight style %q, one of %s", name, strings.Join(styles.Names(), ", "))
+	}
+
+	return &codeHighlighter{
+		formatter: chromahtml.New(
+			chromahtml.WithClasses(options.Classes),
+			// not to clash with classes of blog skin, like .bg
+			chromahtml.ClassPrefix("story-"),
+			chromahtml.WithLineNumbers(options.LineNumbers),
+			chromahtml.TabWidth(4),
+		),
+		style:   style,
+		classes: options.Classes,
+	}, nil
+}
+
+// Extend implements goldmark.Extender.
+func (h *codeHighlighter) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(h, 100)))
+}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (h *codeHighlighter) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, h.renderFencedCodeBlock)
+}
+
+func (h *codeHighlighter) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.FencedCodeBlock)
+	lexer := lexers.Get(string(n.Language(source)))
+	if lexer == nil {
+		return h.renderHTML(w, source, node, entering)
+	}
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var code bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	if h.classes && !h.cssDone {
+		// a stylesheet once, before the first highlighted block
+		w.WriteString("<style>")
+		if err := h.formatter.WriteCSS(w, h.style); err != nil {
+			return ast.WalkStop, err
+		}
+		w.WriteString("</style>\n")
+		h.cssDone = true
+	}
+
+	if err := h.formatter.Format(w, h.style, iterator); err != nil {
+		return ast.WalkStop, err
+	}
+	w.WriteString("\n")
+
+	return ast.WalkSkipChildren, nil
+}
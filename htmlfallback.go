@@ -0,0 +1,43 @@
+package story
+
+import (
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// htmlFallback renders nodes with goldmark html renderer, for node renderers
+// which override only some of the nodes they registered.
+type htmlFallback struct {
+	html  *html.Renderer
+	funcs nodeRendererFuncs
+}
+
+// nodeRendererFuncs collects render functions of a renderer.NodeRenderer.
+type nodeRendererFuncs map[ast.NodeKind]renderer.NodeRendererFunc
+
+func (f nodeRendererFuncs) Register(kind ast.NodeKind, fn renderer.NodeRendererFunc) {
+	f[kind] = fn
+}
+
+func (h *htmlFallback) htmlRenderer() *html.Renderer {
+	if h.html == nil {
+		h.html = &html.Renderer{Config: html.NewConfig()}
+	}
+	return h.html
+}
+
+// SetOption implements renderer.SetOptioner, to render like html renderer.
+func (h *htmlFallback) SetOption(name renderer.OptionName, value interface{}) {
+	h.htmlRenderer().SetOption(name, value)
+}
+
+// renderHTML renders node with html renderer.
+func (h *htmlFallback) renderHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if h.funcs == nil {
+		h.funcs = make(nodeRendererFuncs)
+		h.htmlRenderer().RegisterFuncs(h.funcs)
+	}
+	return h.funcs[node.Kind()](w, source, node, entering)
+}
@@ -2,105 +2,417 @@ package story
 
 import (
 	"bytes"
-	"encoding/json"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
-	"mime/multipart"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 
-	"github.com/russross/blackfriday"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
 )
 
 // TistoryRenderer takes image link and upload image if possible.
-// Overrides blackfriday.Renderer.Image()
+// Links to local files of AttachmentExtensions are uploaded too.
+// It is a goldmark renderer.NodeRenderer of images and links.
 type TistoryRenderer struct {
-	blackfriday.Renderer
-	BlogName    string
-	AccessToken string
-	WorkingDir  string
+	htmlFallback
+
+	Client     *Client
+	File       string
+	WorkingDir string
+
+	// RehostRemoteImages uploads http, https and data: images too.
+	RehostRemoteImages bool
+	// Cache reuses files uploaded before if not nil.
+	Cache *UploadCache
+	// Concurrency is the number of parallel uploads in Prepare.
+	Concurrency int
+	// AttachmentExtensions are extensions of linked files to upload, like ".pdf".
+	AttachmentExtensions []string
+	// MaxUploadSize limits size of uploaded files if positive.
+	MaxUploadSize int64
+	// Images preprocesses images before upload.
+	Images ImageOptions
+
+	// Errors is every failure of uploading files, whose local links are kept.
+	Errors UploadErrors
+
+	uploads map[uploadJob]uploadResult
 }
 
-func (t *TistoryRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
-	// check if file is not local file
-	if _, err := url.Parse(string(link)); err != nil {
-		t.Renderer.Image(out, link, title, alt)
-		return
+// linkKind is kind of link target.
+type linkKind int
+
+const (
+	// linkLocal is a relative or absolute file path, or file:// url.
+	linkLocal linkKind = iota
+	// linkRemote is a http or https url.
+	linkRemote
+	// linkData is a data: url.
+	linkData
+	// linkOther is any other url, like mailto:.
+	linkOther
+)
+
+// classifyLink returns kind of link, and file path if the link is local.
+// Relative file path is joined with workingDir.
+func classifyLink(link string, workingDir string) (linkKind, string) {
+	u, err := url.Parse(link)
+	if err != nil {
+		// not an url, treat as a file path as is
+		return linkLocal, localPath(link, workingDir)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "":
+		if u.Host != "" {
+			// protocol relative url, like //example.com/a.png
+			return linkRemote, ""
+		}
+		return linkLocal, localPath(u.Path, workingDir)
+	case "file":
+		return linkLocal, filepath.FromSlash(u.Path)
+	case "http", "https":
+		return linkRemote, ""
+	case "data":
+		return linkData, ""
+	}
+
+	// windows drive letter, like C:\image.png
+	if len(u.Scheme) == 1 {
+		return linkLocal, link
 	}
 
-	uploadFailed := func(err error) {
+	return linkOther, ""
+}
+
+func localPath(link string, workingDir string) string {
+	if filepath.IsAbs(link) || path.IsAbs(link) {
+		return filepath.FromSlash(link)
+	}
+
+	return filepath.Join(filepath.FromSlash(workingDir), filepath.FromSlash(link))
+}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (t *TistoryRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindImage, t.renderImage)
+	reg.Register(ast.KindLink, t.renderLink)
+}
+
+func (t *TistoryRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	link := string(node.(*ast.Image).Destination)
+	attachment, err := t.resolve(link, true)
+	if err != nil {
 		log.Println("uploading image file error:", err.Error())
-		log.Println("skip uploading file", string(link))
-		t.Renderer.Image(out, link, title, alt)
+		t.Errors = append(t.Errors, &UploadError{File: t.File, Link: link, Err: err})
+	}
+
+	if attachment == nil {
+		return t.renderHTML(w, source, node, entering)
+	}
+
+	w.WriteString(attachment.Replacer)
+	return ast.WalkSkipChildren, nil
+}
+
+func (t *TistoryRenderer) renderLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.Link)
+	link := string(n.Destination)
+	if !entering || !t.isAttachment(link) {
+		return t.renderHTML(w, source, node, entering)
+	}
+
+	attachment, err := t.resolve(link, false)
+	if err != nil {
+		log.Println("uploading file error:", err.Error())
+		t.Errors = append(t.Errors, &UploadError{File: t.File, Link: link, Err: err})
+		return t.renderHTML(w, source, node, entering)
+	}
+
+	n.Destination = []byte(attachment.URL)
+	return t.renderHTML(w, source, node, entering)
+}
+
+// isAttachment reports whether link is a local file of AttachmentExtensions.
+func (t *TistoryRenderer) isAttachment(link string) bool {
+	kind, filename := classifyLink(link, t.WorkingDir)
+	if kind != linkLocal {
+		return false
+	}
+
+	ext := filepath.Ext(filename)
+	for _, attachmentExt := range t.AttachmentExtensions {
+		if strings.EqualFold(ext, attachmentExt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uploadResult is the result of uploading a link in Prepare.
+type uploadResult struct {
+	attachment *Attachment
+	err        error
+}
+
+// uploadJob is a link to upload in Prepare.
+type uploadJob struct {
+	link  string
+	image bool
+}
+
+// Prepare collects image and attachment links of parsed markdown and uploads
+// them in parallel, with Concurrency workers, before rendering.
+func (t *TistoryRenderer) Prepare(doc ast.Node) {
+	var images, attachments []string
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := node.(type) {
+		case *ast.Image:
+			images = append(images, string(n.Destination))
+		case *ast.Link:
+			if t.isAttachment(string(n.Destination)) {
+				attachments = append(attachments, string(n.Destination))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	jobs := make(chan uploadJob)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	concurrency := t.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	t.uploads = make(map[uploadJob]uploadResult)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				attachment, err := t.uploadLink(job.link, job.image)
+
+				mutex.Lock()
+				t.uploads[job] = uploadResult{attachment, err}
+				mutex.Unlock()
+			}
+		}()
 	}
 
-	f, err := os.Open(path.Join(t.WorkingDir, string(link)))
+	seen := make(map[uploadJob]bool)
+	send := func(job uploadJob) {
+		if !seen[job] {
+			seen[job] = true
+			jobs <- job
+		}
+	}
+	for _, link := range images {
+		send(uploadJob{link, true})
+	}
+	for _, link := range attachments {
+		send(uploadJob{link, false})
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// resolve returns attachment of the link uploaded in Prepare, or uploads it now.
+func (t *TistoryRenderer) resolve(link string, image bool) (*Attachment, error) {
+	if result, ok := t.uploads[uploadJob{link, image}]; ok {
+		return result.attachment, result.err
+	}
+
+	return t.uploadLink(link, image)
+}
+
+// uploadLink uploads target of the link, image or attachment.
+// It returns nil attachment if the link should be kept as is.
+func (t *TistoryRenderer) uploadLink(link string, image bool) (*Attachment, error) {
+	kind, filename := classifyLink(link, t.WorkingDir)
+	if kind == linkOther || (kind != linkLocal && !t.RehostRemoteImages) {
+		return nil, nil
+	}
+
+	var r io.ReadCloser
+	var err error
+	switch kind {
+	case linkLocal:
+		r, err = os.Open(filename)
+		filename = filepath.Base(filename)
+	case linkRemote:
+		r, filename, err = t.download(link)
+	case linkData:
+		r, filename, err = decodeDataURL(link)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	var content []byte
+	if t.MaxUploadSize > 0 {
+		// read one more byte to know if the file exceeds the limit
+		content, err = ioutil.ReadAll(io.LimitReader(r, t.MaxUploadSize+1))
+		if err == nil && int64(len(content)) > t.MaxUploadSize {
+			err = fmt.Errorf("%s is larger than %d bytes", filename, t.MaxUploadSize)
+		}
+	} else {
+		content, err = ioutil.ReadAll(r)
+	}
 	if err != nil {
-		uploadFailed(err)
-		return
+		return nil, err
 	}
-	defer f.Close()
 
-	// upload image file
-	var payloadForm bytes.Buffer
-	mpWriter := multipart.NewWriter(&payloadForm)
-	if err := mpWriter.WriteField("access_token", t.AccessToken); err != nil {
-		uploadFailed(err)
-		return
+	if err := checkContentType(filename, content, image); err != nil {
+		return nil, err
 	}
 
-	if err := mpWriter.WriteField("blogName", t.BlogName); err != nil {
-		uploadFailed(err)
-		return
+	if image {
+		if filename, content, err = ProcessImage(filename, content, t.Images); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := mpWriter.WriteField("output", "json"); err != nil {
-		uploadFailed(err)
-		return
+	return t.upload(filename, content)
+}
+
+// sniffedTypes are content types detected from files of extensions.
+var sniffedTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".zip":  "application/zip",
+	".docx": "application/zip",
+	".xlsx": "application/zip",
+	".pptx": "application/zip",
+	".gz":   "application/x-gzip",
+	".tgz":  "application/x-gzip",
+}
+
+// checkContentType sniffs content and rejects it if it does not look like
+// the file name, like an html error page saved as .png.
+func checkContentType(filename string, content []byte, image bool) error {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	mismatch := false
+	switch {
+	case ext == ".svg" || ext == ".html" || ext == ".htm":
+		// text based files
+	case image:
+		mismatch = !strings.HasPrefix(sniffed, "image/") && sniffed != "application/octet-stream"
+	case sniffed == "text/html":
+		mismatch = true
+	case sniffedTypes[ext] != "":
+		mismatch = sniffed != sniffedTypes[ext]
+	}
+
+	if mismatch {
+		return fmt.Errorf("%s looks like %s, not %s", filename, sniffed, ext)
+	}
+	return nil
+}
+
+// upload uploads content, or reuses the attachment of the same content in Cache.
+func (t *TistoryRenderer) upload(filename string, content []byte) (*Attachment, error) {
+	hash := ContentHash(content)
+	if t.Cache != nil {
+		if attachment, ok := t.Cache.Get(t.Client.BlogName, hash); ok {
+			log.Println("uploaded url is", attachment.URL, "(cached)")
+			return attachment, nil
+		}
 	}
 
-	fileWriter, err := mpWriter.CreateFormFile("uploadedfile", path.Base(string(link)))
+	attachment, err := t.Client.AttachFile(filename, bytes.NewReader(content))
 	if err != nil {
-		uploadFailed(err)
-		return
+		return nil, err
 	}
 
-	if _, err := io.Copy(fileWriter, f); err != nil {
-		uploadFailed(err)
-		return
+	log.Println("uploaded url is", attachment.URL)
+	if t.Cache != nil {
+		t.Cache.Put(t.Client.BlogName, hash, filename, attachment)
 	}
+	return attachment, nil
+}
 
-	// flush body content
-	mpWriter.Close()
+// download gets remote image to upload again.
+func (t *TistoryRenderer) download(link string) (io.ReadCloser, string, error) {
+	if strings.HasPrefix(link, "//") {
+		link = "https:" + link
+	}
 
-	// do request
-	resp, err := http.Post("https://www.tistory.com/apis/post/attach", mpWriter.FormDataContentType(), &payloadForm)
+	httpClient := t.Client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Get(link)
 	if err != nil {
-		uploadFailed(err)
-		return
+		return nil, "", err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		uploadFailed(errors.New(parseError(resp.Body)))
-		return
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("download %s: %s", link, resp.Status)
+	}
+
+	filename := path.Base(resp.Request.URL.Path)
+	if filename == "/" || filename == "." {
+		filename = "image"
 	}
 
-	var responseBody struct {
-		Tistory struct {
-			URL      string `json:"url"`
-			Replacer string `json:"replacer"`
-		} `json:"tistory"`
+	return resp.Body, filename, nil
+}
+
+// decodeDataURL decodes data: url, like data:image/png;base64,iVBORw0KGgo...
+func decodeDataURL(link string) (io.ReadCloser, string, error) {
+	comma := strings.IndexByte(link, ',')
+	if comma < 0 {
+		return nil, "", errors.New("invalid data url")
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&responseBody); err != nil {
-		uploadFailed(err)
-		return
+	header, data := link[len("data:"):comma], link[comma+1:]
+	params := strings.Split(header, ";")
+
+	filename := "image"
+	if exts, _ := mime.ExtensionsByType(params[0]); len(exts) > 0 {
+		filename += exts[0]
+	}
+
+	var content []byte
+	var err error
+	if params[len(params)-1] == "base64" {
+		content, err = base64.StdEncoding.DecodeString(data)
+	} else {
+		var unescaped string
+		unescaped, err = url.PathUnescape(data)
+		content = []byte(unescaped)
+	}
+	if err != nil {
+		return nil, "", err
 	}
 
-	log.Println("image url is", responseBody.Tistory.URL)
-	out.WriteString(responseBody.Tistory.Replacer)
+	return ioutil.NopCloser(bytes.NewReader(content)), filename, nil
 }
@@ -0,0 +1,39 @@
+package story
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestClassifyLink(t *testing.T) {
+	workingDir := filepath.Join("posts", "2024")
+
+	tests := []struct {
+		link string
+		kind linkKind
+		path string
+	}{
+		{"http://example.com/a.png", linkRemote, ""},
+		{"HTTPS://example.com/a.png", linkRemote, ""},
+		{"//example.com/a.png", linkRemote, ""},
+		{"data:image/png;base64,iVBORw0KGgo=", linkData, ""},
+		{"file:///images/a.png", linkLocal, filepath.FromSlash("/images/a.png")},
+		{"a.png", linkLocal, filepath.Join(workingDir, "a.png")},
+		{"../images/a.png", linkLocal, filepath.Join("posts", "images", "a.png")},
+		{"/images/a.png", linkLocal, filepath.FromSlash("/images/a.png")},
+		{`C:\x.png`, linkLocal, `C:\x.png`},
+		{"mailto:someone@example.com", linkOther, ""},
+		{"a.pdf#page=2", linkLocal, filepath.Join(workingDir, "a.pdf")},
+		{"my%20image.png", linkLocal, filepath.Join(workingDir, "my image.png")},
+		{"%ED%95%9C%EA%B8%80.png", linkLocal, filepath.Join(workingDir, "한글.png")},
+		// not a valid escape, used as is
+		{"100%.png", linkLocal, filepath.Join(workingDir, "100%.png")},
+	}
+
+	for _, test := range tests {
+		kind, path := classifyLink(test.link, workingDir)
+		if kind != test.kind || path != test.path {
+			t.Errorf("classifyLink(%q) = %v, %q, want %v, %q", test.link, kind, path, test.kind, test.path)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package story
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// math engines, which typeset math of the post in browser.
+const (
+	MathJax = "mathjax"
+	KaTeX   = "katex"
+)
+
+// mathScripts load math engines.
+var mathScripts = map[string]string{
+	MathJax: `<script src="https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-mml-chtml.js" async></script>`,
+	KaTeX: `<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/katex@0.16/dist/katex.min.css">` +
+		`<script defer src="https://cdn.jsdelivr.net/npm/katex@0.16/dist/katex.min.js"></script>` +
+		`<script defer src="https://cdn.jsdelivr.net/npm/katex@0.16/dist/contrib/auto-render.min.js" onload="renderMathInElement(document.body)"></script>`,
+}
+
+// MathOptions controls math written as $...$ and $$...$$.
+type MathOptions struct {
+	// Engine is MathJax or KaTeX. Math is not parsed, and $ is kept as is,
+	// if it is empty or "none".
+	Engine string `yaml:"engine" toml:"engine"`
+	// Script includes script of the engine, if the blog skin does not load it.
+	Script bool `yaml:"script" toml:"script"`
+}
+
+// merge returns options with fields not set filled from other.
+func (o MathOptions) merge(other *MathOptions) MathOptions {
+	if other == nil {
+		return o
+	}
+
+	if o.Engine == "" {
+		o.Engine = other.Engine
+	}
+	o.Script = o.Script || other.Script
+
+	return o
+}
+
+func (o *MathOptions) addFlags(flag *flag.FlagSet) {
+	flag.StringVar(&o.Engine, "math", "", "math engine to render $...$ and $$...$$, mathjax or katex, \"none\" to keep $ as is")
+	flag.BoolVar(&o.Script, "math-script", false, "include script of the math engine in the post")
+}
+
+// KindMath is a NodeKind of inline math.
+var KindMath = ast.NewNodeKind("Math")
+
+// Math is inline math, $...$, or display math in a paragraph, $$...$$.
+type Math struct {
+	ast.BaseInline
+	Display bool
+	Value   []byte
+}
+
+func (n *Math) Kind() ast.NodeKind {
+	return KindMath
+}
+
+func (n *Math) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Value": string(n.Value)}, nil)
+}
+
+// KindMathBlock is a NodeKind of display math block.
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// MathBlock is display math in lines of its own, between $$ lines.
+type MathBlock struct {
+	ast.BaseBlock
+	Value []byte
+
+	closed bool
+}
+
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Value": string(n.Value)}, nil)
+}
+
+// mathInlineParser parses $...$ and $$...$$ in a line. Like pandoc, opening
+// $ should not be followed by a space, and closing $ should not be preceded
+// by a space nor followed by a digit, so prices like $5 and $10 are not math.
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	delimiter := 1
+	if len(line) > 1 && line[1] == '$' {
+		delimiter = 2
+	}
+
+	body := line[delimiter:]
+	if len(body) == 0 || util.IsSpace(body[0]) {
+		return p.notMath(block, segment, delimiter)
+	}
+
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\':
+			// escaped character, like \$
+			i++
+		case body[i] != '$' || i == 0 || util.IsSpace(body[i-1]):
+		case delimiter == 2 && i+1 < len(body) && body[i+1] == '$',
+			delimiter == 1 && (i+1 == len(body) || body[i+1] < '0' || body[i+1] > '9'):
+			block.Advance(delimiter + i + delimiter)
+			return &Math{Display: delimiter == 2, Value: append([]byte(nil), body[:i]...)}
+		}
+	}
+
+	return p.notMath(block, segment, delimiter)
+}
+
+// notMath keeps unmatched $$ as text, so its second $ does not open inline math.
+func (p *mathInlineParser) notMath(block text.Reader, segment text.Segment, delimiter int) ast.Node {
+	if delimiter == 1 {
+		return nil
+	}
+
+	block.Advance(delimiter)
+	return ast.NewTextSegment(segment.WithStop(segment.Start + delimiter))
+}
+
+// mathBlockParser parses display math between $$ lines.
+//
+//	$$
+//	e^{i\pi} + 1 = 0
+//	$$
+type mathBlockParser struct{}
+
+func (p *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+
+	node := &MathBlock{}
+	rest := bytes.TrimSpace(line[pos+2:])
+	if len(rest) >= 2 && bytes.HasSuffix(rest, []byte("$$")) {
+		// $$ ... $$ in a line
+		node.Value = append(node.Value, bytes.TrimSpace(rest[:len(rest)-2])...)
+		node.closed = true
+	} else if !hasClosingLine(reader.Source()[segment.Stop:]) {
+		// not to swallow the rest of the post, left to paragraph
+		return nil, parser.NoChildren
+	} else if len(rest) > 0 {
+		node.Value = append(append(node.Value, rest...), '\n')
+	}
+
+	advanceLine(reader, line, segment)
+	return node, parser.NoChildren
+}
+
+func (p *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	block := node.(*MathBlock)
+	if block.closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 {
+		// display math has no blank line
+		return parser.Close
+	}
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		if content := bytes.TrimSpace(trimmed[:len(trimmed)-2]); len(content) > 0 {
+			block.Value = append(append(block.Value, content...), '\n')
+		}
+		advanceLine(reader, line, segment)
+		return parser.Close
+	}
+
+	block.Value = append(block.Value, line...)
+	advanceLine(reader, line, segment)
+	return parser.Continue | parser.NoChildren
+}
+
+// hasClosingLine reports whether a line ending with $$ comes in source,
+// before a blank line.
+func hasClosingLine(source []byte) bool {
+	for len(source) > 0 {
+		line := source
+		if i := bytes.IndexByte(source, '\n'); i >= 0 {
+			line, source = source[:i], source[i+1:]
+		} else {
+			source = nil
+		}
+
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			return false
+		}
+		if bytes.HasSuffix(trimmed, []byte("$$")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// advanceLine advances reader to the end of line, before newline.
+func advanceLine(reader text.Reader, line []byte, segment text.Segment) {
+	n := segment.Len()
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		n--
+	}
+	reader.Advance(n)
+}
+
+func (p *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+}
+
+func (p *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// mathExtension parses math, and renders it as \(...\) and \[...\] which both
+// MathJax and KaTeX auto-render typeset. Since math is parsed before other
+// inlines, it is not touched by emphasis or smart punctuation.
+type mathExtension struct {
+	options MathOptions
+	used    bool
+}
+
+// newMathExtension returns math extension of options, or nil if math is disabled.
+func newMathExtension(options MathOptions) (*mathExtension, error) {
+	switch strings.ToLower(options.Engine) {
+	case MathJax, KaTeX:
+		options.Engine = strings.ToLower(options.Engine)
+	case "", "none":
+		// opt-in, not to break posts with $ in text, like $HOME
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown math engine %q, one of %s, %s or none", options.Engine, MathJax, KaTeX)
+	}
+
+	return &mathExtension{options: options}, nil
+}
+
+// Extend implements goldmark.Extender.
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 650)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 50)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(e, 100)))
+}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (e *mathExtension) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMath, e.renderMath)
+	reg.Register(KindMathBlock, e.renderMathBlock)
+}
+
+func (e *mathExtension) renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*Math)
+	if n.Display {
+		w.WriteString(`<span class="math display">\[`)
+		w.Write(util.EscapeHTML(n.Value))
+		w.WriteString(`\]</span>`)
+	} else {
+		w.WriteString(`<span class="math inline">\(`)
+		w.Write(util.EscapeHTML(n.Value))
+		w.WriteString(`\)</span>`)
+	}
+
+	e.used = true
+	return ast.WalkSkipChildren, nil
+}
+
+func (e *mathExtension) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	w.WriteString(`<div class="math display">\[`)
+	w.Write(util.EscapeHTML(bytes.TrimSpace(node.(*MathBlock).Value)))
+	w.WriteString("\\]</div>\n")
+
+	e.used = true
+	return ast.WalkSkipChildren, nil
+}
+
+// script returns script of the engine to be appended to rendered content,
+// or empty if it is not needed.
+func (e *mathExtension) script() string {
+	if !e.used || !e.options.Script {
+		return ""
+	}
+
+	return mathScripts[e.options.Engine]
+}
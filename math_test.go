@@ -0,0 +1,150 @@
+package story
+
+import (
+	"bytes"
+	"testing"
+)
+
+func renderMath(t *testing.T, options MathOptions, source string) (string, *mathExtension) {
+	t.Helper()
+
+	math, err := newMathExtension(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	if err := newMarkdown(&TistoryRenderer{}, math).Convert([]byte(source), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.String(), math
+}
+
+func TestMath(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		html   string
+	}{
+		{
+			name:   "inline",
+			source: `Euler's identity is $e^{i\pi} + 1 = 0$.`,
+			html:   "<p>Euler&rsquo;s identity is <span class=\"math inline\">\\(e^{i\\pi} + 1 = 0\\)</span>.</p>\n",
+		},
+		{
+			name:   "prices",
+			source: "$5 and $10",
+			html:   "<p>$5 and $10</p>\n",
+		},
+		{
+			name:   "space after opening",
+			source: "$ x$ and $$ y$$",
+			html:   "<p>$ x$ and $$ y$$</p>\n",
+		},
+		{
+			name:   "space before closing",
+			source: "$x $ here",
+			html:   "<p>$x $ here</p>\n",
+		},
+		{
+			name:   "space before closing display",
+			source: "so $$y $$ here",
+			html:   "<p>so $$y $$ here</p>\n",
+		},
+		{
+			name:   "digit after closing",
+			source: "$x$5",
+			html:   "<p>$x$5</p>\n",
+		},
+		{
+			name:   "escaped",
+			source: `\$x\$ and $\$$`,
+			html:   "<p>$x$ and <span class=\"math inline\">\\(\\$\\)</span></p>\n",
+		},
+		{
+			name:   "display in paragraph",
+			source: "so $$x^2$$ is",
+			html:   "<p>so <span class=\"math display\">\\[x^2\\]</span> is</p>\n",
+		},
+		{
+			name:   "one line block",
+			source: "$$ x^2 $$",
+			html:   "<div class=\"math display\">\\[x^2\\]</div>\n",
+		},
+		{
+			name:   "multi line block",
+			source: "text\n$$\na < b\n\\\\ c\n$$\nafter",
+			html:   "<p>text</p>\n<div class=\"math display\">\\[a &lt; b\n\\\\ c\\]</div>\n<p>after</p>\n",
+		},
+		{
+			name:   "block with math on delimiter lines",
+			source: "$$ a\nb $$",
+			html:   "<div class=\"math display\">\\[a\nb\\]</div>\n",
+		},
+		{
+			name:   "unclosed block",
+			source: "$$ is what we want, right?\n\n# Next section\n\nMore text with *emphasis*.",
+			html:   "<p>$$ is what we want, right?</p>\n<h1>Next section</h1>\n<p>More text with <em>emphasis</em>.</p>\n",
+		},
+		{
+			name:   "block closed after blank line",
+			source: "$$\nx\n\ny $$",
+			html:   "<p>$$\nx</p>\n<p>y $$</p>\n",
+		},
+		{
+			name:   "typographer and emphasis",
+			source: `"quoted" -- $a*b*c -- "d"...$`,
+			html:   "<p>&ldquo;quoted&rdquo; &ndash; <span class=\"math inline\">\\(a*b*c -- &quot;d&quot;...\\)</span></p>\n",
+		},
+		{
+			name:   "block untouched by typographer",
+			source: "$$\n\"a\" -- b...\n$$",
+			html:   "<div class=\"math display\">\\[&quot;a&quot; -- b...\\]</div>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if html, _ := renderMath(t, MathOptions{Engine: MathJax}, test.source); html != test.html {
+				t.Errorf("rendered %q, want %q", html, test.html)
+			}
+		})
+	}
+}
+
+func TestMathEngine(t *testing.T) {
+	tests := []struct {
+		engine  string
+		enabled bool
+		fail    bool
+	}{
+		{"", false, false},
+		{"none", false, false},
+		{"mathjax", true, false},
+		{"KaTeX", true, false},
+		{"latex", false, true},
+	}
+
+	for _, test := range tests {
+		math, err := newMathExtension(MathOptions{Engine: test.engine})
+		if (err != nil) != test.fail {
+			t.Errorf("%q: err = %v", test.engine, err)
+		}
+		if (math != nil) != test.enabled {
+			t.Errorf("%q: enabled = %v, want %v", test.engine, math != nil, test.enabled)
+		}
+	}
+}
+
+func TestMathScript(t *testing.T) {
+	if _, math := renderMath(t, MathOptions{Engine: KaTeX, Script: true}, "no math, $5"); math.script() != "" {
+		t.Errorf("script of post without math = %q", math.script())
+	}
+	if _, math := renderMath(t, MathOptions{Engine: KaTeX}, "$x$"); math.script() != "" {
+		t.Errorf("script without Script option = %q", math.script())
+	}
+	if _, math := renderMath(t, MathOptions{Engine: KaTeX, Script: true}, "$x$"); math.script() != mathScripts[KaTeX] {
+		t.Errorf("script = %q, want %q", math.script(), mathScripts[KaTeX])
+	}
+}
@@ -0,0 +1,132 @@
+package story
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultOAuthURL is the root of tistory OAuth endpoints.
+const DefaultOAuthURL = "https://www.tistory.com/oauth"
+
+// OAuthError is an error response of tistory OAuth.
+type OAuthError struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("oauth error (http %d): %s", e.StatusCode, e.Description)
+	}
+
+	return fmt.Sprintf("oauth error %s (http %d): %s", e.Code, e.StatusCode, e.Description)
+}
+
+func (config *InitConfig) oauthURL(api string) string {
+	base := config.OAuthURL
+	if base == "" {
+		base = DefaultOAuthURL
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + api
+}
+
+func (config *InitConfig) redirectURI() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/%s", config.RedirectPort, config.RedirectPath)
+}
+
+func (config *InitConfig) authorizeURL(state string) string {
+	authQuery := url.Values{}
+	authQuery.Add("response_type", "code")
+	authQuery.Add("client_id", config.ClientID)
+	authQuery.Add("redirect_uri", config.redirectURI())
+	authQuery.Add("state", state)
+
+	return config.oauthURL("authorize") + "?" + authQuery.Encode()
+}
+
+// newOAuthState returns random state to verify redirection is from our authorization request.
+func newOAuthState() (string, error) {
+	var buffer [16]byte
+	if _, err := rand.Read(buffer[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buffer[:]), nil
+}
+
+// exchangeCode requests access token with authorization code.
+func (config *InitConfig) exchangeCode(code string) (string, error) {
+	if code == "" {
+		return "", errors.New("missing authorization code")
+	}
+
+	exchangeQuery := url.Values{}
+	exchangeQuery.Add("client_id", config.ClientID)
+	exchangeQuery.Add("client_secret", config.ClientSecret)
+	exchangeQuery.Add("redirect_uri", config.redirectURI())
+	exchangeQuery.Add("code", code)
+	exchangeQuery.Add("grant_type", "authorization_code")
+
+	exchangeResp, err := http.Get(config.oauthURL("access_token") + "?" + exchangeQuery.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer exchangeResp.Body.Close()
+
+	body, err := ioutil.ReadAll(exchangeResp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return parseTokenResponse(exchangeResp.StatusCode, exchangeResp.Header.Get("Content-Type"), body)
+}
+
+// parseTokenResponse reads access token from form encoded or json response.
+func parseTokenResponse(statusCode int, contentType string, body []byte) (string, error) {
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := strings.TrimSpace(string(body))
+	if mediaType == "application/json" || strings.HasPrefix(trimmed, "{") {
+		if err := json.Unmarshal(body, &token); err != nil {
+			return "", &OAuthError{StatusCode: statusCode, Description: trimmed}
+		}
+	} else {
+		values, err := url.ParseQuery(trimmed)
+		if err != nil {
+			return "", &OAuthError{StatusCode: statusCode, Description: trimmed}
+		}
+
+		token.AccessToken = values.Get("access_token")
+		token.Error = values.Get("error")
+		token.ErrorDescription = values.Get("error_description")
+	}
+
+	if token.Error != "" {
+		return "", &OAuthError{StatusCode: statusCode, Code: token.Error, Description: token.ErrorDescription}
+	}
+
+	if statusCode != http.StatusOK {
+		return "", &OAuthError{StatusCode: statusCode, Description: trimmed}
+	}
+
+	if token.AccessToken == "" {
+		return "", &OAuthError{StatusCode: statusCode, Description: "no access token in response"}
+	}
+
+	return token.AccessToken, nil
+}
@@ -0,0 +1,284 @@
+package story
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newTestInitConfig(t *testing.T, handler http.HandlerFunc) *InitConfig {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return &InitConfig{
+		RedirectPort: 8080,
+		RedirectPath: "callback",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		OAuthURL:     server.URL,
+		Path:         filepath.Join(t.TempDir(), "config.json"),
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		token       string
+		oauthError  *OAuthError
+	}{
+		{
+			name:        "form encoded",
+			status:      http.StatusOK,
+			contentType: "text/html",
+			body:        "access_token=form-token",
+			token:       "form-token",
+		},
+		{
+			name:        "json",
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"access_token":"json-token","token_type":"bearer"}`,
+			token:       "json-token",
+		},
+		{
+			name:        "json without content type",
+			status:      http.StatusOK,
+			contentType: "text/plain",
+			body:        `{"access_token":"json-token"}`,
+			token:       "json-token",
+		},
+		{
+			name:        "json error",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        `{"error":"invalid_grant","error_description":"code expired"}`,
+			oauthError:  &OAuthError{StatusCode: http.StatusBadRequest, Code: "invalid_grant", Description: "code expired"},
+		},
+		{
+			name:        "form error",
+			status:      http.StatusBadRequest,
+			contentType: "text/html",
+			body:        "error=invalid_request&error_description=bad+code",
+			oauthError:  &OAuthError{StatusCode: http.StatusBadRequest, Code: "invalid_request", Description: "bad code"},
+		},
+		{
+			name:        "not ok",
+			status:      http.StatusInternalServerError,
+			contentType: "text/html",
+			body:        "<html>server error</html>",
+			oauthError:  &OAuthError{StatusCode: http.StatusInternalServerError, Description: "<html>server error</html>"},
+		},
+		{
+			name:        "missing access token",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"token_type":"bearer"}`,
+			oauthError:  &OAuthError{StatusCode: http.StatusOK, Description: "no access token in response"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newTestInitConfig(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/access_token" {
+					t.Errorf("path = %q, want /access_token", r.URL.Path)
+				}
+				query := r.URL.Query()
+				for key, value := range map[string]string{
+					"client_id":     "client",
+					"client_secret": "secret",
+					"redirect_uri":  "http://127.0.0.1:8080/callback",
+					"code":          "the-code",
+					"grant_type":    "authorization_code",
+				} {
+					if query.Get(key) != value {
+						t.Errorf("%s = %q, want %q", key, query.Get(key), value)
+					}
+				}
+
+				w.Header().Set("Content-Type", test.contentType)
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			})
+
+			token, err := config.exchangeCode("the-code")
+			if test.oauthError == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if token != test.token {
+					t.Errorf("token = %q, want %q", token, test.token)
+				}
+				return
+			}
+
+			var oauthErr *OAuthError
+			if !errors.As(err, &oauthErr) {
+				t.Fatalf("err = %v, want OAuthError", err)
+			}
+			if *oauthErr != *test.oauthError {
+				t.Errorf("err = %+v, want %+v", oauthErr, test.oauthError)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeWithoutCode(t *testing.T) {
+	config := newTestInitConfig(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected request")
+	})
+
+	if _, err := config.exchangeCode(""); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestRunOAuthServer(t *testing.T) {
+	var exchanged []string
+	exchange := func(code string) (string, error) {
+		exchanged = append(exchanged, code)
+		return "token-of-" + code, nil
+	}
+
+	server, chanToken := runOAuthServer(0, "callback", "the-state", exchange)
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"state mismatch", "/callback?code=bad&state=other", http.StatusBadRequest},
+		{"no state", "/callback?code=bad", http.StatusBadRequest},
+		{"other path", "/favicon.ico", http.StatusNotFound},
+		{"ok", "/callback?code=good&state=the-state", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+
+	if len(exchanged) != 1 || exchanged[0] != "good" {
+		t.Errorf("exchanged codes = %v, want [good]", exchanged)
+	}
+	if token := <-chanToken; token != "token-of-good" {
+		t.Errorf("token = %q, want token-of-good", token)
+	}
+}
+
+// redirectInput pastes the redirected url with the state printed to out.
+type redirectInput struct {
+	out  *bytes.Buffer
+	code string
+	read bool
+}
+
+var stateParam = regexp.MustCompile(`state=([0-9a-f]+)`)
+
+func (r *redirectInput) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, fmt.Errorf("read twice")
+	}
+	r.read = true
+
+	match := stateParam.FindStringSubmatch(r.out.String())
+	if match == nil {
+		return 0, fmt.Errorf("no state in %q", r.out.String())
+	}
+
+	return copy(p, fmt.Sprintf("http://127.0.0.1:8080/callback?code=%s&state=%s\n", r.code, match[1])), nil
+}
+
+func TestAuthorizeManual(t *testing.T) {
+	var codes []string
+	config := newTestInitConfig(t, func(w http.ResponseWriter, r *http.Request) {
+		codes = append(codes, r.URL.Query().Get("code"))
+		fmt.Fprint(w, "access_token=manual-token")
+	})
+
+	var out bytes.Buffer
+	if err := config.AuthorizeManual(&redirectInput{out: &out, code: "the-code"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if config.AccessToken != "manual-token" {
+		t.Errorf("access token = %q, want manual-token", config.AccessToken)
+	}
+	if len(codes) != 1 || codes[0] != "the-code" {
+		t.Errorf("exchanged codes = %v, want [the-code]", codes)
+	}
+}
+
+func TestAuthorizeManualStateMismatch(t *testing.T) {
+	config := newTestInitConfig(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("code of other authorization is exchanged")
+	})
+
+	var out bytes.Buffer
+	in := strings.NewReader("http://127.0.0.1:8080/callback?code=the-code&state=other\n")
+	err := config.AuthorizeManual(in, &out)
+	if err == nil || !strings.Contains(err.Error(), "state mismatch") {
+		t.Errorf("err = %v, want state mismatch", err)
+	}
+	if config.AccessToken != "" {
+		t.Errorf("access token = %q, want empty", config.AccessToken)
+	}
+}
+
+func TestAuthorizeSavesTokenOverEncryptedSecrets(t *testing.T) {
+	t.Setenv("STORY_PASSPHRASE", "passphrase")
+	t.Setenv("STORY_CLIENT_SECRET", "")
+	t.Setenv("STORY_ACCESS_TOKEN", "")
+
+	config := newTestInitConfig(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "access_token=new")
+	})
+	config.AccessToken = "old"
+	config.Encrypt = true
+	if err := config.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// both secrets from environment, so nothing is decrypted on load
+	t.Setenv("STORY_CLIENT_SECRET", "env-secret")
+	t.Setenv("STORY_ACCESS_TOKEN", "env-token")
+	loaded := InitConfig{Path: config.Path, OAuthURL: config.OAuthURL}
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := loaded.AuthorizeManual(&redirectInput{out: &out, code: "the-code"}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("STORY_CLIENT_SECRET", "")
+	t.Setenv("STORY_ACCESS_TOKEN", "")
+	reloaded := InitConfig{Path: config.Path}
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.AccessToken != "new" {
+		t.Errorf("access token = %q, want new", reloaded.AccessToken)
+	}
+	// client secret from environment is not saved
+	if reloaded.ClientSecret != "secret" {
+		t.Errorf("client secret = %q, want secret", reloaded.ClientSecret)
+	}
+	if reloaded.EncryptedSecrets == nil {
+		t.Error("secrets are saved without encryption")
+	}
+}
@@ -7,35 +7,41 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
-
-	"github.com/russross/blackfriday"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
 )
 
-const (
-	// copied from github.com/russross/blackfriday/markdown.go
-	commonHtmlFlags = blackfriday.HTML_USE_XHTML |
-		blackfriday.HTML_USE_SMARTYPANTS |
-		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
-		blackfriday.HTML_SMARTYPANTS_DASHES |
-		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
-
-	commonExtensions = 0 |
-		blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
-		blackfriday.EXTENSION_TABLES |
-		blackfriday.EXTENSION_FENCED_CODE |
-		blackfriday.EXTENSION_AUTOLINK |
-		blackfriday.EXTENSION_STRIKETHROUGH |
-		blackfriday.EXTENSION_SPACE_HEADERS |
-		blackfriday.EXTENSION_HEADER_IDS |
-		blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
-		blackfriday.EXTENSION_DEFINITION_LISTS
+var (
+	// GFM tables, strikethrough, autolinks and task lists, footnotes,
+	// definition lists and smart punctuation
+	commonExtensions = []goldmark.Extender{
+		extension.GFM,
+		extension.Footnote,
+		extension.DefinitionList,
+		extension.Typographer,
+	}
+
+	// headers with {#id}
+	commonParserOptions = []parser.Option{
+		parser.WithAttribute(),
+	}
+
+	// raw html in markdown is kept as is
+	commonHtmlOptions = []renderer.Option{
+		html.WithXHTML(),
+		html.WithUnsafe(),
+	}
 )
 
 // "item":{
@@ -55,45 +61,217 @@ const (
 // 	"date":"1303352668"
 // }
 type TistoryPost struct {
-	ID              string `json:"id"`
-	Title           string `json:"title"`
-	Content         string `json:"content"`
-	CategoryID      int    `json:"categoryId,string"`
-	PostURL         string `json:"postUrl"`
-	Visibility      int    `json:"visibility,string"`
-	AcceptComment   int    `json:"acceptCommnet,string"`
-	AcceptTrackback int    `json:"acceptTrackback,string"`
-	Comments        int    `json:"comments,string"`
-	Trackbacks      int    `json:"trackbacks,string"`
-	Date            string `json:"date"`
-	// Tags            struct {
-	// Tag []string `json:"tag"`
-	// } `json:"tags"`
+	ID              string      `json:"id"`
+	Title           string      `json:"title"`
+	Content         string      `json:"content"`
+	CategoryID      int         `json:"categoryId,string"`
+	PostURL         string      `json:"postUrl"`
+	Visibility      int         `json:"visibility,string"`
+	AcceptComment   int         `json:"acceptComment,string"`
+	AcceptTrackback int         `json:"acceptTrackback,string"`
+	Comments        int         `json:"comments,string"`
+	Trackbacks      int         `json:"trackbacks,string"`
+	Date            string      `json:"date"`
+	Tags            TistoryTags `json:"tags"`
+}
+
+// TistoryTags reads {"tag": [...]} of post/read. tag is a string if the post has only one tag.
+type TistoryTags []string
+
+func (t *TistoryTags) UnmarshalJSON(data []byte) error {
+	// post without tags may have empty string
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		*t = nil
+		return nil
+	}
+
+	var tags struct {
+		Tag json.RawMessage `json:"tag"`
+	}
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return err
+	}
+
+	if len(tags.Tag) == 0 {
+		*t = nil
+		return nil
+	}
+
+	var tag string
+	if err := json.Unmarshal(tags.Tag, &tag); err == nil {
+		*t = TistoryTags{tag}
+		return nil
+	}
+
+	return json.Unmarshal(tags.Tag, (*[]string)(t))
+}
+
+// PostParams returns parameters of post/modify which keep the post as is.
+func (post *TistoryPost) PostParams() *PostParams {
+	acceptComment := post.AcceptComment != 0
+	return &PostParams{
+		PostID:        post.ID,
+		Title:         post.Title,
+		Content:       post.Content,
+		Visibility:    readVisibility(post.Visibility),
+		CategoryID:    post.CategoryID,
+		Tags:          post.Tags,
+		AcceptComment: &acceptComment,
+	}
+}
+
+// readVisibility converts visibility of post/read and post/list into Visibility.
+func readVisibility(visibility int) Visibility {
+	switch visibility {
+	case 15:
+		return VisibilityProtected
+	case 20:
+		return VisibilityPublic
+	default:
+		return Visibility(visibility)
+	}
+}
+
+// PostOptions are flags of post metadata shared by `story post` and `story edit`.
+type PostOptions struct {
+	Tags       stringList
+	Category   string
+	Visibility string
+	Published  string
+	Password   string
+
+	// parsed from Visibility and Published
+	parsed     bool
+	visibility Visibility
+	published  time.Time
+}
+
+func (o *PostOptions) addFlags(flag *flag.FlagSet) {
+	flag.Var(&o.Tags, "tag", "post tag, can be repeated")
+	flag.StringVar(&o.Category, "category", "", "category id, label (ex> Dev/Go) or name")
+	flag.StringVar(&o.Visibility, "visibility", "", "private, protected or public")
+	flag.StringVar(&o.Published, "published", "", "schedule the post at the future time, ex> \"2006-01-02 15:04\"")
+	flag.StringVar(&o.Password, "password", "", "password of protected post")
+}
+
+func (o *PostOptions) empty() bool {
+	return len(o.Tags) == 0 && o.Category == "" && o.Visibility == "" && o.Published == "" && o.Password == ""
 }
 
-func parseError(r io.Reader) string {
-	var responseBody struct {
-		Tistory struct {
-			Status       string `json:"status"`
-			ErrorMessage string `json:"error_message"`
+// parse checks visibility and published time, to fail before images of the
+// post are uploaded. Category is checked in Apply, which needs api requests.
+func (o *PostOptions) parse() error {
+	if o.Visibility != "" {
+		visibility, err := ParseVisibility(o.Visibility)
+		if err != nil {
+			return err
 		}
+		o.visibility = visibility
 	}
 
-	if err := json.NewDecoder(r).Decode(&responseBody); err != nil {
-		return err.Error()
+	if o.Published != "" {
+		published, err := parsePublished(o.Published)
+		if err != nil {
+			return err
+		}
+		o.published = published
 	}
 
-	return fmt.Sprintf("code %s: %s", responseBody.Tistory.Status, responseBody.Tistory.ErrorMessage)
+	o.parsed = true
+	return nil
 }
 
+// Apply overrides params with the flags specified.
+func (o *PostOptions) Apply(client *Client, params *PostParams) error {
+	if !o.parsed {
+		if err := o.parse(); err != nil {
+			return err
+		}
+	}
+
+	if len(o.Tags) > 0 {
+		params.Tags = o.Tags
+	}
+
+	if o.Category != "" {
+		id, err := client.CategoryID(o.Category)
+		if err != nil {
+			return err
+		}
+		params.CategoryID = id
+	}
+
+	if o.Visibility != "" {
+		params.Visibility = o.visibility
+	}
+
+	if o.Published != "" {
+		params.Published = o.published
+	}
+
+	if o.Password != "" {
+		params.Password = o.Password
+	}
+
+	return nil
+}
+
+// parseTime parses unix timestamp or date time in local time zone.
+func parseTime(s string) (time.Time, error) {
+	if timestamp, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(timestamp, 0), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New("invalid time: " + s)
+}
+
+// parsePublished parses scheduled time of a post, which should be in the future.
+func parsePublished(s string) (time.Time, error) {
+	published, err := parseTime(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !published.After(time.Now()) {
+		return time.Time{}, errors.New("published time must be in the future: " + s)
+	}
+
+	return published, nil
+}
+
+// stringList is a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var errMissingBlogName = errors.New("missing blog name, use -blog or select default blog with `story auth`")
+
 type ViewConfig struct {
 	BlogName string
 	PostID   string
+	// Retry is retry policy of api requests, DefaultRetryPolicy if nil.
+	Retry *RetryPolicy
 }
 
 func (c *ViewConfig) Parse(args []string) error {
 	flag := flag.NewFlagSet("story show", flag.ExitOnError)
-	flag.StringVar(&c.BlogName, "blog", "", "tistory blog name, ex> {blog}.tistory.com")
+	flag.StringVar(&c.BlogName, "blog", c.BlogName, "tistory blog name, ex> {blog}.tistory.com")
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "story show -blog=[blog id] [options] postID")
 		flag.PrintDefaults()
@@ -110,54 +288,109 @@ func (c *ViewConfig) Parse(args []string) error {
 	c.PostID = flag.Arg(0)
 
 	if c.BlogName == "" {
-		return errors.New("missing blog name")
+		return errMissingBlogName
 	}
 
 	return nil
 }
 
 func (config *ViewConfig) Do(accessToken string) (*TistoryPost, error) {
-	query := url.Values{}
-	query.Add("access_token", accessToken)
-	query.Add("blogName", config.BlogName)
-	query.Add("postId", config.PostID)
-	query.Add("output", "json")
+	return newClient(accessToken, config.BlogName, config.Retry).ReadPost(config.PostID)
+}
+
+type ListConfig struct {
+	BlogName string
+	Category string
+	JSON     bool
+	// Retry is retry policy of api requests, DefaultRetryPolicy if nil.
+	Retry *RetryPolicy
+}
 
-	resp, err := http.Get("https://www.tistory.com/apis/post/read?" + query.Encode())
+func (c *ListConfig) Parse(args []string) error {
+	flag := flag.NewFlagSet("story list", flag.ExitOnError)
+	flag.StringVar(&c.BlogName, "blog", c.BlogName, "tistory blog name, ex> {blog}.tistory.com")
+	flag.StringVar(&c.Category, "category", "", "if specified, list posts of the category name or id only")
+	flag.BoolVar(&c.JSON, "json", false, "print posts as json")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "story list -blog=[blog id] [options]")
+		flag.PrintDefaults()
+	}
+
+	if err := flag.Parse(args); err != nil {
+		return err
+	}
+
+	if c.BlogName == "" {
+		return errMissingBlogName
+	}
+
+	return nil
+}
+
+// Do reads every page of post/list and returns posts which match the config.
+func (config *ListConfig) Do(accessToken string) ([]TistoryPost, error) {
+	client := newClient(accessToken, config.BlogName, config.Retry)
+	posts, err := client.ListAllPosts()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(parseError(resp.Body))
+	if config.Category == "" {
+		return posts, nil
 	}
 
-	var post struct {
-		Tistory struct {
-			Item TistoryPost `json:"item"`
-		} `json:"tistory"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&post); err != nil {
+	categoryID, err := client.CategoryID(config.Category)
+	if err != nil {
 		return nil, err
 	}
 
-	return &post.Tistory.Item, nil
+	filtered := posts[:0]
+	for _, post := range posts {
+		if post.CategoryID == categoryID {
+			filtered = append(filtered, post)
+		}
+	}
+
+	return filtered, nil
+}
+
+// Print writes posts as a table, or json if config.JSON is set.
+func (config *ListConfig) Print(w io.Writer, posts []TistoryPost) error {
+	if config.JSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(posts)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tTITLE\tDATE\tVISIBILITY\tURL")
+	for _, post := range posts {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", post.ID, post.Title, post.Date, readVisibility(post.Visibility), post.PostURL)
+	}
+
+	return table.Flush()
 }
 
 type PostConfig struct {
+	PostOptions
+	RenderOptions
 	BlogName string
 	Title    string
 	File     string
 	DryRun   bool
+	// Retry is retry policy of api requests, DefaultRetryPolicy if nil.
+	Retry *RetryPolicy
 }
 
 func (c *PostConfig) Parse(args []string) error {
 	flag := flag.NewFlagSet("story post", flag.ExitOnError)
-	flag.StringVar(&c.BlogName, "blog", "", "tistory blog name, ex> {blog}.tistory.com")
+	flag.StringVar(&c.BlogName, "blog", c.BlogName, "tistory blog name, ex> {blog}.tistory.com")
 	flag.BoolVar(&c.DryRun, "n", false, "actually do nothing")
+	c.PostOptions.addFlags(flag)
+	c.RenderOptions.addFlags(flag)
 	flag.Usage = func() {
 		fmt.Println("story post -blog=[blog id] [title] [markdown file or directory]")
+		fmt.Println("title can be omitted if it is given in front matter")
 		flag.PrintDefaults()
 	}
 
@@ -166,124 +399,85 @@ func (c *PostConfig) Parse(args []string) error {
 	}
 
 	if c.BlogName == "" {
-		return errors.New("missing blog name")
+		return errMissingBlogName
 	}
 
-	c.File = filepath.ToSlash(flag.Arg(1))
-	if _, err := os.Stat(c.File); err != nil {
-		return err
+	switch flag.NArg() {
+	case 1:
+		c.File = filepath.ToSlash(flag.Arg(0))
+	case 2:
+		c.Title = flag.Arg(0)
+		c.File = filepath.ToSlash(flag.Arg(1))
+	default:
+		flag.Usage()
+		return errors.New("invalid arguments")
 	}
 
-	c.Title = flag.Arg(0)
-	if c.Title == "" {
-		return errors.New("missing title")
+	if _, err := os.Stat(c.File); err != nil {
+		return err
 	}
 
-	return nil
+	return c.PostOptions.parse()
 }
 
 func (config *PostConfig) Do(accessToken string) error {
-	query := url.Values{}
-	query.Add("access_token", accessToken)
-	query.Add("blogName", config.BlogName)
-	query.Add("title", config.Title)
-	query.Add("output", "json")
-
-	appendFile := func(content io.Writer, filename string) error {
-		filename = filepath.ToSlash(filename)
-		fileContent, err := ioutil.ReadFile(filename)
-		if err != nil {
-			return err
-		}
-
-		renderer := TistoryRenderer{
-			Renderer:    blackfriday.HtmlRenderer(commonHtmlFlags, "", ""),
-			AccessToken: accessToken,
-			BlogName:    config.BlogName,
-			WorkingDir:  path.Dir(filename),
-		}
-
-		if _, err = content.Write([]byte(`<div class="markdown">`)); err != nil {
-			return err
-		}
-		if _, err = content.Write(blackfriday.Markdown(fileContent, &renderer, commonExtensions)); err != nil {
-			return err
-		}
-		if _, err = content.Write([]byte(`</div>`)); err != nil {
-			return err
-		}
-
+	client := newClient(accessToken, config.BlogName, config.Retry)
+	content, matter, err := RenderContent(client, config.File, &config.RenderOptions)
+	if err != nil {
 		return err
 	}
 
-	if stat, _ := os.Stat(config.File); stat.IsDir() {
-		files, err := filepath.Glob(path.Join(config.File, "*.md"))
-		if err != nil {
+	params := PostParams{Content: content}
+	if matter != nil {
+		if err := matter.Apply(client, &params); err != nil {
 			return err
-		} else if len(files) == 0 {
-			return errors.New("no .md files found")
 		}
+	}
 
-		var buffer bytes.Buffer
-		for i := range files {
-			log.Println("reading", files[i])
-			if err := appendFile(&buffer, files[i]); err != nil {
-				return err
-			}
-		}
+	if config.Title != "" {
+		params.Title = config.Title
+	}
 
-		query.Add("content", buffer.String())
-	} else {
-		var buffer bytes.Buffer
-		log.Println("reading", config.File)
-		if err := appendFile(&buffer, config.File); err != nil {
-			return err
-		}
+	if err := config.PostOptions.Apply(client, &params); err != nil {
+		return err
+	}
 
-		query.Add("content", buffer.String())
+	if params.Title == "" {
+		return errors.New("missing title")
 	}
 
 	if !config.DryRun {
-		resp, err := http.Post("https://www.tistory.com/apis/post/write", "application/x-www-form-urlencoded", bytes.NewBufferString(query.Encode()))
+		postURL, err := client.WritePost(&params)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return errors.New(parseError(resp.Body))
-		}
-
-		var respBody struct {
-			Tistory struct {
-				URL string `json:"url"`
-			} `json:"tistory"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-			return err
-		}
 
-		log.Println("post url:", respBody.Tistory.URL)
+		log.Println("post url:", postURL)
 	}
 
 	return nil
 }
 
 type EditConfig struct {
+	PostOptions
+	RenderOptions
 	BlogName string
 	Title    string
 	File     string
 	PostID   string
 	DryRun   bool
+	// Retry is retry policy of api requests, DefaultRetryPolicy if nil.
+	Retry *RetryPolicy
 }
 
 func (c *EditConfig) Parse(args []string) error {
 	flag := flag.NewFlagSet("story edit", flag.ExitOnError)
-	flag.StringVar(&c.BlogName, "blog", "", "tistory blog name, ex> {blog}.tistory.com")
+	flag.StringVar(&c.BlogName, "blog", c.BlogName, "tistory blog name, ex> {blog}.tistory.com")
 	flag.StringVar(&c.Title, "title", "", "if specified, also change the title")
 	flag.StringVar(&c.File, "content", "", "if specified, update the content")
 	flag.BoolVar(&c.DryRun, "n", false, "actually do nothing")
+	c.PostOptions.addFlags(flag)
+	c.RenderOptions.addFlags(flag)
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage: story edit [options] postID")
 		flag.PrintDefaults()
@@ -299,122 +493,68 @@ func (c *EditConfig) Parse(args []string) error {
 	}
 
 	if c.BlogName == "" {
-		return errors.New("missing blog name")
+		return errMissingBlogName
 	}
 
-	if c.File == "" && c.Title == "" {
+	if c.File == "" && c.Title == "" && c.PostOptions.empty() {
 		return errors.New("nothing to do")
 	}
 
-	c.File = filepath.ToSlash(c.File)
-	if _, err := os.Stat(c.File); err != nil {
+	if err := c.PostOptions.parse(); err != nil {
 		return err
 	}
 
+	if c.File != "" {
+		c.File = filepath.ToSlash(c.File)
+		if _, err := os.Stat(c.File); err != nil {
+			return err
+		}
+	}
+
 	c.PostID = flag.Arg(0)
 	return nil
 }
 
 func (config *EditConfig) Do(accessToken string) error {
-	view := ViewConfig{BlogName: config.BlogName, PostID: config.PostID}
-	post, err := view.Do(accessToken)
-	if err != nil {
+	client := newClient(accessToken, config.BlogName, config.Retry)
+	post, err := client.ReadPost(config.PostID)
+	if IsNotFound(err) {
+		return fmt.Errorf("post %s is not found in %s: %w", config.PostID, config.BlogName, err)
+	} else if err != nil {
 		return err
 	}
 
-	title := post.Title
-	if config.Title != "" {
-		title = config.Title
-	}
-
-	content := post.Content
-
+	params := post.PostParams()
+	params.PostID = config.PostID
 	if config.File != "" {
-		appendFile := func(content io.Writer, filename string) error {
-			filename = filepath.ToSlash(filename)
-			fileContent, err := ioutil.ReadFile(filename)
-			if err != nil {
-				return err
-			}
-
-			renderer := TistoryRenderer{
-				Renderer:    blackfriday.HtmlRenderer(commonHtmlFlags, "", ""),
-				AccessToken: accessToken,
-				BlogName:    config.BlogName,
-				WorkingDir:  path.Dir(filename),
-			}
-
-			if _, err = content.Write([]byte(`<div class="markdown">`)); err != nil {
-				return err
-			}
-			if _, err = content.Write(blackfriday.Markdown(fileContent, &renderer, commonExtensions)); err != nil {
-				return err
-			}
-			if _, err = content.Write([]byte(`</div>`)); err != nil {
-				return err
-			}
-
-			return nil
+		content, matter, err := RenderContent(client, config.File, &config.RenderOptions)
+		if err != nil {
+			return err
 		}
 
-		if stat, _ := os.Stat(config.File); stat.IsDir() {
-			files, err := filepath.Glob(path.Join(config.File, "*.md"))
-			if err != nil {
+		params.Content = content
+		if matter != nil {
+			if err := matter.Apply(client, params); err != nil {
 				return err
-			} else if len(files) == 0 {
-				return errors.New("no .md files found")
-			}
-
-			var buffer bytes.Buffer
-			for i := range files {
-				log.Println("reading", files[i])
-				if err := appendFile(&buffer, files[i]); err != nil {
-					return err
-				}
 			}
+		}
+	}
 
-			content = buffer.String()
-		} else {
-			var buffer bytes.Buffer
-			log.Println("reading", config.File)
-			if err := appendFile(&buffer, config.File); err != nil {
-				return err
-			}
+	if config.Title != "" {
+		params.Title = config.Title
+	}
 
-			content = buffer.String()
-		}
+	if err := config.PostOptions.Apply(client, params); err != nil {
+		return err
 	}
 
 	if !config.DryRun {
-		query := url.Values{}
-		query.Add("access_token", accessToken)
-		query.Add("blogName", config.BlogName)
-		query.Add("title", title)
-		query.Add("content", content)
-		query.Add("postId", config.PostID)
-		query.Add("output", "json")
-
-		resp, err := http.Post("https://www.tistory.com/apis/post/modify", "application/x-www-form-urlencoded", bytes.NewBufferString(query.Encode()))
+		postURL, err := client.ModifyPost(params)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return errors.New(parseError(resp.Body))
-		}
-
-		var respBody struct {
-			Tistory struct {
-				URL string `json:"url"`
-			} `json:"tistory"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-			return err
-		}
 
-		log.Println("post url:", respBody.Tistory.URL)
+		log.Println("post url:", postURL)
 	}
 
 	return nil
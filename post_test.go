@@ -0,0 +1,71 @@
+package story
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostOptionsParse(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"valid", []string{"-visibility=public", "-published=2030-05-01 10:00"}, ""},
+		{"invalid visibility", []string{"-visibility=publc"}, "invalid visibility"},
+		{"past published", []string{"-published=2020-05-01 10:00"}, "must be in the future"},
+		{"invalid published", []string{"-published=tomorrow"}, "invalid time"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			post := PostConfig{BlogName: "myblog"}
+			postErr := post.Parse(append(test.args, "testdata/basic.md"))
+
+			edit := EditConfig{BlogName: "myblog"}
+			editErr := edit.Parse(append(test.args, "12"))
+
+			for command, err := range map[string]error{"post": postErr, "edit": editErr} {
+				if test.err == "" && err != nil {
+					t.Errorf("%s: %v", command, err)
+				}
+				if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+					t.Errorf("%s: err = %v, want %q", command, err, test.err)
+				}
+			}
+		})
+	}
+}
+
+func TestPostOptionsApply(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("KST", 9*60*60)
+	defer func() { time.Local = local }()
+
+	options := PostOptions{Tags: stringList{"go"}, Visibility: "protected", Published: "2030-05-01 10:00", Password: "secret"}
+	if err := options.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	params := PostParams{Visibility: VisibilityPrivate}
+	if err := options.Apply(nil, &params); err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Visibility != VisibilityProtected {
+		t.Errorf("visibility = %v, want protected", params.Visibility)
+	}
+	if want := time.Date(2030, 5, 1, 10, 0, 0, 0, time.Local); !params.Published.Equal(want) {
+		t.Errorf("published = %v, want %v", params.Published, want)
+	}
+	if len(params.Tags) != 1 || params.Tags[0] != "go" || params.Password != "secret" {
+		t.Errorf("params = %+v", params)
+	}
+
+	// options not parsed yet, like set by library callers
+	unparsed := PostOptions{Visibility: "publc"}
+	if err := unparsed.Apply(nil, &params); err == nil {
+		t.Error("invalid visibility is applied")
+	}
+}
@@ -0,0 +1,369 @@
+package story
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// defaultJPEGQuality is used to encode JPEG if ImageOptions.JPEGQuality is not set.
+const defaultJPEGQuality = 85
+
+// ImageOptions controls preprocessing of images before upload.
+// Zero value uploads images as is.
+type ImageOptions struct {
+	// MaxWidth and MaxHeight scale down larger images, keeping aspect ratio.
+	MaxWidth  int `yaml:"maxWidth" toml:"maxWidth"`
+	MaxHeight int `yaml:"maxHeight" toml:"maxHeight"`
+	// JPEGQuality is quality of re-encoded JPEG, 1 to 100.
+	JPEGQuality int `yaml:"jpegQuality" toml:"jpegQuality"`
+	// PNGToJPEGAbove converts PNG larger than this bytes to JPEG if positive.
+	PNGToJPEGAbove int64 `yaml:"pngToJpegAbove" toml:"pngToJpegAbove"`
+	// StripEXIF removes EXIF and other metadata, like GPS location.
+	StripEXIF bool `yaml:"stripExif" toml:"stripExif"`
+}
+
+// merge returns options with fields not set filled from other.
+func (o ImageOptions) merge(other *ImageOptions) ImageOptions {
+	if other == nil {
+		return o
+	}
+
+	if o.MaxWidth == 0 {
+		o.MaxWidth = other.MaxWidth
+	}
+	if o.MaxHeight == 0 {
+		o.MaxHeight = other.MaxHeight
+	}
+	if o.JPEGQuality == 0 {
+		o.JPEGQuality = other.JPEGQuality
+	}
+	if o.PNGToJPEGAbove == 0 {
+		o.PNGToJPEGAbove = other.PNGToJPEGAbove
+	}
+	o.StripEXIF = o.StripEXIF || other.StripEXIF
+
+	return o
+}
+
+func (o *ImageOptions) addFlags(flag *flag.FlagSet) {
+	flag.IntVar(&o.MaxWidth, "max-width", 0, "scale down images wider than this pixels")
+	flag.IntVar(&o.MaxHeight, "max-height", 0, "scale down images taller than this pixels")
+	flag.IntVar(&o.JPEGQuality, "jpeg-quality", 0, fmt.Sprintf("quality of re-encoded JPEG images, %d if 0", defaultJPEGQuality))
+	flag.Int64Var(&o.PNGToJPEGAbove, "png-to-jpeg", 0, "convert PNG images larger than this bytes to JPEG")
+	flag.BoolVar(&o.StripEXIF, "strip-exif", false, "remove EXIF and other metadata, like GPS location, from images")
+}
+
+// ProcessImage resizes, converts and strips metadata of a JPEG or PNG image
+// as options. Other images are returned as is. filename is returned with
+// extension changed if the image is converted to JPEG.
+func ProcessImage(filename string, content []byte, options ImageOptions) (string, []byte, error) {
+	if options == (ImageOptions{}) {
+		return filename, content, nil
+	}
+
+	format := http.DetectContentType(content)
+	if format != "image/jpeg" && format != "image/png" {
+		return filename, content, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	orientation := 1
+	if format == "image/jpeg" {
+		orientation = jpegOrientation(content)
+	}
+
+	width, height := config.Width, config.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	newWidth, newHeight := fitSize(width, height, options.MaxWidth, options.MaxHeight)
+
+	resize := newWidth != width || newHeight != height
+	convert := format == "image/png" && options.PNGToJPEGAbove > 0 && int64(len(content)) > options.PNGToJPEGAbove
+	// re-encoding drops metadata, so orientation should be applied to pixels
+	rotate := options.StripEXIF && orientation != 1
+
+	if !resize && !convert && !rotate {
+		if options.StripEXIF {
+			return filename, stripMetadata(format, content), nil
+		}
+		return filename, content, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	img = orient(img, orientation)
+	if resize {
+		scaled := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+		img = scaled
+		log.Printf("resized %s from %dx%d to %dx%d", filename, width, height, newWidth, newHeight)
+	}
+
+	var output bytes.Buffer
+	if format == "image/jpeg" || convert {
+		quality := options.JPEGQuality
+		if quality <= 0 {
+			quality = defaultJPEGQuality
+		}
+
+		if convert {
+			// JPEG has no alpha channel, put transparent pixels on white
+			opaque := image.NewRGBA(img.Bounds())
+			draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+			draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+			img = opaque
+		}
+
+		err = jpeg.Encode(&output, img, &jpeg.Options{Quality: quality})
+	} else {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&output, img)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if convert {
+		log.Printf("converted %s to JPEG, %d bytes to %d bytes", filename, len(content), output.Len())
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg"
+	}
+
+	return filename, output.Bytes(), nil
+}
+
+// fitSize returns size scaled down to fit in maxWidth and maxHeight,
+// which are not limited if zero.
+func fitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		if s := float64(maxHeight) / float64(height); s < scale {
+			scale = s
+		}
+	}
+
+	if scale == 1.0 {
+		return width, height
+	}
+
+	newWidth, newHeight := int(float64(width)*scale+0.5), int(float64(height)*scale+0.5)
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+	return newWidth, newHeight
+}
+
+// orient rotates and flips img as EXIF orientation, 1 to 8.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontal
+				sx, sy = w-1-x, y
+			case 3: // rotate 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertical
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 90 counterclockwise
+				sx, sy = w-1-y, x
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegSegments calls fn with marker and payload of each segment before
+// image data, and returns offset of the start of scan segment, or -1 if
+// content is not a valid JPEG.
+func jpegSegments(content []byte, fn func(marker byte, payload []byte)) int {
+	if len(content) < 2 || content[0] != 0xff || content[1] != 0xd8 {
+		return -1
+	}
+
+	offset := 2
+	for offset+4 <= len(content) {
+		if content[offset] != 0xff {
+			return -1
+		}
+
+		marker := content[offset+1]
+		if marker == 0xff {
+			// fill byte
+			offset++
+			continue
+		}
+		if marker == 0xda {
+			return offset
+		}
+
+		length := int(binary.BigEndian.Uint16(content[offset+2:]))
+		if length < 2 || offset+2+length > len(content) {
+			return -1
+		}
+
+		fn(marker, content[offset+4:offset+2+length])
+		offset += 2 + length
+	}
+
+	return -1
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegOrientation returns EXIF orientation of JPEG, or 1 if not found.
+func jpegOrientation(content []byte) int {
+	orientation := 1
+	jpegSegments(content, func(marker byte, payload []byte) {
+		if marker != 0xe1 || !bytes.HasPrefix(payload, exifHeader) {
+			return
+		}
+
+		tiff := payload[len(exifHeader):]
+		if len(tiff) < 8 {
+			return
+		}
+
+		var order binary.ByteOrder
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return
+		}
+
+		ifd := int(order.Uint32(tiff[4:]))
+		if ifd+2 > len(tiff) {
+			return
+		}
+
+		count := int(order.Uint16(tiff[ifd:]))
+		for i := 0; i < count; i++ {
+			entry := ifd + 2 + i*12
+			if entry+12 > len(tiff) {
+				return
+			}
+
+			// orientation tag is a SHORT
+			if order.Uint16(tiff[entry:]) == 0x0112 {
+				if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+					orientation = value
+				}
+				return
+			}
+		}
+	})
+
+	return orientation
+}
+
+// pngMetadataChunks are PNG chunks removed by stripMetadata.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripMetadata removes EXIF, XMP and comments of JPEG, or metadata chunks
+// of PNG, without re-encoding. Content is returned as is if it is malformed.
+func stripMetadata(format string, content []byte) []byte {
+	var stripped bytes.Buffer
+
+	switch format {
+	case "image/jpeg":
+		stripped.Write(content[:2])
+		scan := jpegSegments(content, func(marker byte, payload []byte) {
+			// APP1 holds EXIF and XMP, COM is comment
+			if marker == 0xe1 || marker == 0xfe {
+				return
+			}
+
+			stripped.Write([]byte{0xff, marker})
+			binary.Write(&stripped, binary.BigEndian, uint16(len(payload)+2))
+			stripped.Write(payload)
+		})
+		if scan < 0 {
+			return content
+		}
+
+		stripped.Write(content[scan:])
+
+	case "image/png":
+		const signatureSize = 8
+		stripped.Write(content[:signatureSize])
+		for offset := signatureSize; offset < len(content); {
+			if offset+12 > len(content) {
+				return content
+			}
+
+			length := int(binary.BigEndian.Uint32(content[offset:]))
+			end := offset + 12 + length
+			if length < 0 || end > len(content) {
+				return content
+			}
+
+			if !pngMetadataChunks[string(content[offset+4:offset+8])] {
+				stripped.Write(content[offset:end])
+			}
+			offset = end
+		}
+
+	default:
+		return content
+	}
+
+	return stripped.Bytes()
+}
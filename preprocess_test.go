@@ -0,0 +1,302 @@
+package story
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// testdata images are 16x8, red on the left half and blue on the right.
+// JPEGs have EXIF with orientation and GPS latitude, and the PNG has tEXt
+// chunk and transparent right half.
+func readTestImage(t *testing.T, name string) []byte {
+	content, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+func decodeTestImage(t *testing.T, content []byte) image.Image {
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xc000 && g < 0x4000 && b < 0x4000
+}
+
+func isBlue(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r < 0x4000 && g < 0x4000 && b > 0xc000
+}
+
+func isWhite(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xf000 && g > 0xf000 && b > 0xf000
+}
+
+func hasMetadata(content []byte) bool {
+	return bytes.Contains(content, []byte("Exif")) || bytes.Contains(content, []byte("tEXt"))
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation int
+	}{
+		{"orientation-1.jpg", 1},
+		{"orientation-6.jpg", 6},
+		{"orientation-8.jpg", 8},
+		{"text-alpha.png", 1},
+	}
+
+	for _, test := range tests {
+		if orientation := jpegOrientation(readTestImage(t, test.name)); orientation != test.orientation {
+			t.Errorf("%s: orientation = %d, want %d", test.name, orientation, test.orientation)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2, numbered pixels
+	//  0 1 2
+	//  3 4 5
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Pix[i*4] = uint8(i)
+	}
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{2, [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{3, [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{4, [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{5, [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{6, [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{7, [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{8, [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+	}
+
+	for _, test := range tests {
+		img := orient(src, test.orientation)
+		bounds := img.Bounds()
+		if bounds.Dx() != len(test.want[0]) || bounds.Dy() != len(test.want) {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d", test.orientation, bounds.Dx(), bounds.Dy(), len(test.want[0]), len(test.want))
+			continue
+		}
+
+		for y, row := range test.want {
+			for x, want := range row {
+				if r, _, _, _ := img.At(x, y).RGBA(); uint8(r>>8) != want {
+					t.Errorf("orientation %d: pixel (%d, %d) = %d, want %d", test.orientation, x, y, r>>8, want)
+				}
+			}
+		}
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth, maxHeight int
+		newWidth, newHeight                int
+	}{
+		{1600, 1200, 0, 0, 1600, 1200},
+		{1600, 1200, 2000, 2000, 1600, 1200},
+		{1600, 1200, 800, 0, 800, 600},
+		{1600, 1200, 0, 600, 800, 600},
+		{1600, 1200, 800, 300, 400, 300},
+		{1200, 1600, 800, 800, 600, 800},
+		{1000, 3, 100, 0, 100, 1},
+		{3, 1000, 0, 100, 1, 100},
+	}
+
+	for _, test := range tests {
+		width, height := fitSize(test.width, test.height, test.maxWidth, test.maxHeight)
+		if width != test.newWidth || height != test.newHeight {
+			t.Errorf("fitSize(%d, %d, %d, %d) = %d, %d, want %d, %d",
+				test.width, test.height, test.maxWidth, test.maxHeight, width, height, test.newWidth, test.newHeight)
+		}
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{"orientation-6.jpg", "image/jpeg"},
+		{"text-alpha.png", "image/png"},
+	}
+
+	for _, test := range tests {
+		content := readTestImage(t, test.name)
+		if !hasMetadata(content) {
+			t.Fatalf("%s: no metadata to strip", test.name)
+		}
+
+		stripped := stripMetadata(test.format, content)
+		if hasMetadata(stripped) || bytes.Contains(stripped, []byte("taken at home")) {
+			t.Errorf("%s: metadata is not stripped", test.name)
+		}
+
+		// pixels are kept as is
+		before, after := decodeTestImage(t, content), decodeTestImage(t, stripped)
+		if before.Bounds() != after.Bounds() {
+			t.Errorf("%s: bounds = %v, want %v", test.name, after.Bounds(), before.Bounds())
+		}
+		if before.At(12, 4) != after.At(12, 4) {
+			t.Errorf("%s: pixel = %v, want %v", test.name, after.At(12, 4), before.At(12, 4))
+		}
+	}
+
+	// malformed content is returned as is
+	malformed := []byte{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff}
+	if stripped := stripMetadata("image/jpeg", malformed); !bytes.Equal(stripped, malformed) {
+		t.Errorf("malformed jpeg = %x, want %x", stripped, malformed)
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		options  ImageOptions
+		filename string
+		format   string
+		width    int
+		height   int
+		// top and bottom are colors at the center of top and bottom rows
+		top, bottom func(color.Color) bool
+		metadata    bool
+	}{
+		{
+			name:     "as is",
+			file:     "orientation-6.jpg",
+			filename: "orientation-6.jpg",
+			format:   "image/jpeg",
+			width:    16, height: 8,
+			metadata: true,
+		},
+		{
+			name:     "strip without rotation",
+			file:     "orientation-1.jpg",
+			options:  ImageOptions{StripEXIF: true},
+			filename: "orientation-1.jpg",
+			format:   "image/jpeg",
+			width:    16, height: 8,
+		},
+		{
+			name:     "strip rotates clockwise",
+			file:     "orientation-6.jpg",
+			options:  ImageOptions{StripEXIF: true},
+			filename: "orientation-6.jpg",
+			format:   "image/jpeg",
+			width:    8, height: 16,
+			top: isRed, bottom: isBlue,
+		},
+		{
+			name:     "strip rotates counterclockwise",
+			file:     "orientation-8.jpg",
+			options:  ImageOptions{StripEXIF: true},
+			filename: "orientation-8.jpg",
+			format:   "image/jpeg",
+			width:    8, height: 16,
+			top: isBlue, bottom: isRed,
+		},
+		{
+			name:     "resize after rotation",
+			file:     "orientation-6.jpg",
+			options:  ImageOptions{MaxHeight: 8},
+			filename: "orientation-6.jpg",
+			format:   "image/jpeg",
+			width:    4, height: 8,
+			top: isRed, bottom: isBlue,
+		},
+		{
+			name:     "strip png",
+			file:     "text-alpha.png",
+			options:  ImageOptions{StripEXIF: true},
+			filename: "text-alpha.png",
+			format:   "image/png",
+			width:    16, height: 8,
+		},
+		{
+			name:     "png to jpeg",
+			file:     "text-alpha.png",
+			options:  ImageOptions{PNGToJPEGAbove: 1},
+			filename: "text-alpha.jpg",
+			format:   "image/jpeg",
+			width:    16, height: 8,
+		},
+		{
+			name:     "small png is kept",
+			file:     "text-alpha.png",
+			options:  ImageOptions{PNGToJPEGAbove: 1 << 20},
+			filename: "text-alpha.png",
+			format:   "image/png",
+			width:    16, height: 8,
+			metadata: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename, content, err := ProcessImage(test.file, readTestImage(t, test.file), test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if filename != test.filename {
+				t.Errorf("filename = %q, want %q", filename, test.filename)
+			}
+			if format := http.DetectContentType(content); format != test.format {
+				t.Errorf("format = %q, want %q", format, test.format)
+			}
+			if metadata := hasMetadata(content); metadata != test.metadata {
+				t.Errorf("metadata = %v, want %v", metadata, test.metadata)
+			}
+			if !test.metadata && bytes.Contains(content, []byte("taken at home")) {
+				t.Error("text of metadata is not stripped")
+			}
+
+			img := decodeTestImage(t, content)
+			if img.Bounds().Dx() != test.width || img.Bounds().Dy() != test.height {
+				t.Errorf("size = %dx%d, want %dx%d", img.Bounds().Dx(), img.Bounds().Dy(), test.width, test.height)
+			}
+			if test.top != nil && !test.top(img.At(test.width/2, 2)) {
+				t.Errorf("top = %v", img.At(test.width/2, 2))
+			}
+			if test.bottom != nil && !test.bottom(img.At(test.width/2, test.height-3)) {
+				t.Errorf("bottom = %v", img.At(test.width/2, test.height-3))
+			}
+		})
+	}
+}
+
+func TestProcessImageTransparentToWhite(t *testing.T) {
+	_, content, err := ProcessImage("a.png", readTestImage(t, "text-alpha.png"), ImageOptions{PNGToJPEGAbove: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := decodeTestImage(t, content)
+	if left := img.At(3, 4); !isRed(left) {
+		t.Errorf("opaque pixel = %v, want red", left)
+	}
+	if right := img.At(12, 4); !isWhite(right) {
+		t.Errorf("transparent pixel = %v, want white", right)
+	}
+}
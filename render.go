@@ -0,0 +1,268 @@
+package story
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// RenderOptions controls rendering markdown into post content.
+type RenderOptions struct {
+	// AllowBrokenImages keeps local links of images failed to upload, instead of failing.
+	AllowBrokenImages bool
+	// RehostRemoteImages uploads http, https and data: images too.
+	RehostRemoteImages bool
+	// NoCache uploads every file again instead of reusing files uploaded before.
+	NoCache bool
+	// CachePath is upload cache file. DefaultCachePath is used if empty.
+	CachePath string
+	// Concurrency is the number of parallel uploads.
+	Concurrency int
+	// AttachmentExtensions are extensions of linked local files to upload, like ".pdf".
+	// DefaultAttachmentExtensions is used if nil, and no file is uploaded if empty.
+	AttachmentExtensions []string
+	// MaxUploadSize limits size of uploaded files if positive.
+	MaxUploadSize int64
+	// Images preprocesses images before upload. Fields not set are taken from front matter.
+	Images ImageOptions
+	// Highlight controls syntax highlighting of code blocks. Fields not set are taken from front matter.
+	Highlight HighlightOptions
+	// Math controls rendering of $...$ and $$...$$. Fields not set are taken from front matter.
+	Math MathOptions
+}
+
+// DefaultAttachmentExtensions are extensions of linked files uploaded by default.
+var DefaultAttachmentExtensions = []string{
+	".pdf", ".zip", ".7z", ".gz", ".tgz",
+	".mp3", ".m4a", ".wav", ".ogg", ".mp4",
+	".docx", ".xlsx", ".pptx", ".hwp",
+}
+
+func (o *RenderOptions) addFlags(flag *flag.FlagSet) {
+	flag.BoolVar(&o.RehostRemoteImages, "rehost-images", false, "upload remote and data: images to tistory too")
+	flag.BoolVar(&o.NoCache, "no-cache", false, "upload every file again instead of reusing files uploaded before")
+	flag.IntVar(&o.Concurrency, "concurrency", 4, "number of parallel uploads")
+	o.AttachmentExtensions = DefaultAttachmentExtensions
+	flag.Var((*extensionList)(&o.AttachmentExtensions), "attach-ext", "comma separated extensions of linked files to upload")
+	flag.Int64Var(&o.MaxUploadSize, "max-upload-size", 20<<20, "max bytes of an uploaded file")
+	o.Images.addFlags(flag)
+	o.Highlight.addFlags(flag)
+	o.Math.addFlags(flag)
+	flag.BoolVar(&o.AllowBrokenImages, "allow-broken-images", false, "keep local links of images failed to upload, instead of failing")
+}
+
+// extensionList is a flag of comma separated extensions.
+type extensionList []string
+
+func (l *extensionList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *extensionList) Set(value string) error {
+	// empty, not nil, to upload no attachment with -attach-ext ""
+	*l = []string{}
+	for _, ext := range strings.Split(value, ",") {
+		if ext = strings.TrimSpace(ext); ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		*l = append(*l, ext)
+	}
+
+	return nil
+}
+
+// UploadError is a failure of uploading a local file linked from markdown.
+type UploadError struct {
+	File string
+	Link string
+	Err  error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Link, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// UploadErrors is every upload failure during rendering.
+type UploadErrors []*UploadError
+
+func (e UploadErrors) Error() string {
+	lines := make([]string, len(e))
+	for i := range e {
+		lines[i] = "  " + e[i].Error()
+	}
+
+	return fmt.Sprintf("failed to upload %d file(s):\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+// newMarkdown returns markdown converter of post content,
+// whose images and links are rendered by r, with extensions
+// like code highlighting and math.
+func newMarkdown(r *TistoryRenderer, extensions ...goldmark.Extender) goldmark.Markdown {
+	rendererOptions := append([]renderer.Option{renderer.WithNodeRenderers(util.Prioritized(r, 100))}, commonHtmlOptions...)
+
+	return goldmark.New(
+		goldmark.WithExtensions(append(extensions, commonExtensions...)...),
+		goldmark.WithParserOptions(commonParserOptions...),
+		goldmark.WithRendererOptions(rendererOptions...),
+	)
+}
+
+// renderFile renders a markdown file into tistory post content.
+// Local images are uploaded with client.
+func renderFile(content io.Writer, client *Client, cache *UploadCache, filename string, options *RenderOptions) (*FrontMatter, UploadErrors, error) {
+	filename = filepath.ToSlash(filename)
+	fileContent, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matter, fileContent, err := ParseFrontMatter(fileContent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	renderer := TistoryRenderer{
+		Client:     client,
+		File:       filename,
+		WorkingDir: path.Dir(filename),
+
+		RehostRemoteImages: options.RehostRemoteImages,
+		Cache:              cache,
+		Concurrency:        options.Concurrency,
+
+		AttachmentExtensions: options.AttachmentExtensions,
+		MaxUploadSize:        options.MaxUploadSize,
+	}
+	if renderer.AttachmentExtensions == nil {
+		renderer.AttachmentExtensions = DefaultAttachmentExtensions
+	}
+	highlight, math := options.Highlight, options.Math
+	if matter != nil {
+		renderer.Images = options.Images.merge(matter.Images)
+		highlight = highlight.merge(matter.Highlight)
+		math = math.merge(matter.Math)
+	} else {
+		renderer.Images = options.Images
+	}
+
+	var extensions []goldmark.Extender
+	highlighter, err := newCodeHighlighter(highlight)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	} else if highlighter != nil {
+		extensions = append(extensions, highlighter)
+	}
+
+	mathExtension, err := newMathExtension(math)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	} else if mathExtension != nil {
+		extensions = append(extensions, mathExtension)
+	}
+
+	markdown := newMarkdown(&renderer, extensions...)
+	doc := markdown.Parser().Parse(text.NewReader(fileContent))
+	renderer.Prepare(doc)
+
+	if _, err = content.Write([]byte(`<div class="markdown">`)); err != nil {
+		return nil, nil, err
+	}
+	if err = markdown.Renderer().Render(content, fileContent, doc); err != nil {
+		return nil, nil, err
+	}
+	if mathExtension != nil {
+		if _, err = io.WriteString(content, mathExtension.script()); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err = content.Write([]byte(`</div>`)); err != nil {
+		return nil, nil, err
+	}
+
+	return matter, renderer.Errors, nil
+}
+
+// RenderContent renders a markdown file, or every .md file in a directory,
+// into tistory post content. Front matter is stripped from each file and
+// the first one found is returned, or nil if no file has it.
+//
+// If any local image fails to upload, it returns UploadErrors
+// unless options.AllowBrokenImages is set.
+func RenderContent(client *Client, file string, options *RenderOptions) (string, *FrontMatter, error) {
+	if options == nil {
+		options = &RenderOptions{}
+	}
+
+	var files []string
+	if stat, err := os.Stat(file); err != nil {
+		return "", nil, err
+	} else if stat.IsDir() {
+		if files, err = filepath.Glob(path.Join(file, "*.md")); err != nil {
+			return "", nil, err
+		} else if len(files) == 0 {
+			return "", nil, errors.New("no .md files found")
+		}
+	} else {
+		files = []string{file}
+	}
+
+	var cache *UploadCache
+	if !options.NoCache {
+		var err error
+		if cache, err = OpenUploadCache(options.CachePath); err != nil {
+			return "", nil, err
+		}
+
+		defer func() {
+			if err := cache.Save(); err != nil {
+				log.Println("failed to save upload cache:", err)
+			}
+		}()
+	}
+
+	var buffer bytes.Buffer
+	var frontMatter *FrontMatter
+	var uploadErrors UploadErrors
+	for i := range files {
+		log.Println("reading", files[i])
+		matter, fileErrors, err := renderFile(&buffer, client, cache, files[i], options)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if frontMatter == nil {
+			frontMatter = matter
+		}
+		uploadErrors = append(uploadErrors, fileErrors...)
+	}
+
+	if len(uploadErrors) > 0 {
+		if !options.AllowBrokenImages {
+			return "", nil, uploadErrors
+		}
+
+		log.Println("keep local links of broken images,", uploadErrors)
+	}
+
+	return buffer.String(), frontMatter, nil
+}
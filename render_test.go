@@ -0,0 +1,76 @@
+package story
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files of testdata")
+
+// newUploadStub returns a client of a fake server, which accepts every
+// attachment and replies url and replacer of its file name.
+func newUploadStub(t *testing.T) *Client {
+	return newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/post/attach" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+
+		_, header, err := r.FormFile("uploadedfile")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		fmt.Fprintf(w, `{"tistory":{"status":"200","url":"https://blog.example/attachment/%[1]s","replacer":"[##_1N|%[1]s_##]"}}`, header.Filename)
+	})
+}
+
+// TestRenderGolden renders testdata/*.md and compares them with *.golden.html.
+// Run with -update to write the golden files.
+func TestRenderGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no testdata")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			var content bytes.Buffer
+			_, uploadErrors, err := renderFile(&content, newUploadStub(t), nil, file, &RenderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, uploadError := range uploadErrors {
+				// kept as local links in golden files
+				fmt.Fprintf(&content, "\n<!-- upload error: %s -->", uploadError.Link)
+			}
+
+			golden := strings.TrimSuffix(file, ".md") + ".golden.html"
+			if *update {
+				if err := ioutil.WriteFile(golden, content.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content.Bytes(), want) {
+				t.Errorf("rendered content differs from %s\ngot:\n%s\nwant:\n%s", golden, content.Bytes(), want)
+			}
+		})
+	}
+}
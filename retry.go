@@ -0,0 +1,103 @@
+package story
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether and when to retry failed API requests.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one. 1 or less disables retry.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled on each retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay, including Retry-After of the response.
+	MaxDelay time.Duration
+	// RetryUnsafe also retries post/write, which may create duplicated posts.
+	RetryUnsafe bool
+}
+
+// DefaultRetryPolicy returns the policy of clients created with NewClient.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+// idempotent reports whether the request can be sent again without side effects.
+// Retrying post/attach may leave an unused attachment, but no visible change.
+func idempotent(api string, req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+
+	return api == "post/modify" || api == "post/attach"
+}
+
+// retryable reports whether the request failed with err should be sent again.
+func (p *RetryPolicy) retryable(api string, req *http.Request, err error) bool {
+	if !p.RetryUnsafe && !idempotent(api, req) {
+		return false
+	}
+
+	// body of the request cannot be sent again
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests || apiErr.code() == http.StatusTooManyRequests
+	}
+
+	// transport error, like connection reset
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// delay returns exponential backoff with jitter before the retry of attempt,
+// or Retry-After of the response if given.
+func (p *RetryPolicy) delay(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if p.MaxDelay > 0 && apiErr.RetryAfter > p.MaxDelay {
+			return p.MaxDelay
+		}
+		return apiErr.RetryAfter
+	}
+
+	delay := p.BaseDelay << uint(attempt-1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+
+	// full jitter in [delay/2, delay)
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half))
+	}
+
+	return delay
+}
+
+// parseRetryAfter reads Retry-After header in seconds or http date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
@@ -0,0 +1,235 @@
+package story
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// EncryptedSecrets holds client secret and access token encrypted with
+// AES-GCM, using a key derived from passphrase with scrypt.
+type EncryptedSecrets struct {
+	Salt  []byte
+	Nonce []byte
+	Data  []byte
+}
+
+type secrets struct {
+	ClientSecret string
+	AccessToken  string
+}
+
+func deriveKey(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encryptSecrets(passphrase []byte, plain secrets) (*EncryptedSecrets, error) {
+	encrypted := EncryptedSecrets{Salt: make([]byte, 16)}
+	if _, err := rand.Read(encrypted.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := deriveKey(passphrase, encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(encrypted.Nonce); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted.Data = aead.Seal(nil, encrypted.Nonce, data, nil)
+	return &encrypted, nil
+}
+
+func (e *EncryptedSecrets) decrypt(passphrase []byte) (secrets, error) {
+	var plain secrets
+	aead, err := deriveKey(passphrase, e.Salt)
+	if err != nil {
+		return plain, err
+	}
+
+	data, err := aead.Open(nil, e.Nonce, e.Data, nil)
+	if err != nil {
+		return plain, errors.New("failed to decrypt secrets, wrong passphrase?")
+	}
+
+	return plain, json.Unmarshal(data, &plain)
+}
+
+// readPassphrase gets passphrase from $STORY_PASSPHRASE, PassphraseCommand or terminal, in order.
+func (c *InitConfig) readPassphrase(confirm bool) ([]byte, error) {
+	if c.passphrase != nil {
+		return c.passphrase, nil
+	}
+
+	if passphrase := os.Getenv("STORY_PASSPHRASE"); passphrase != "" {
+		c.passphrase = []byte(passphrase)
+		return c.passphrase, nil
+	}
+
+	if c.PassphraseCommand != "" {
+		args := strings.Fields(c.PassphraseCommand)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("passphrase command: %v", err)
+		}
+
+		// like pass, use the first line of output
+		c.passphrase = bytes.TrimRight(bytes.SplitN(output, []byte("\n"), 2)[0], "\r")
+		return c.passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no passphrase, set $STORY_PASSPHRASE or passphrase command")
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		confirmed, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(passphrase, confirmed) {
+			return nil, errors.New("passphrase does not match")
+		}
+	}
+
+	c.passphrase = passphrase
+	return c.passphrase, nil
+}
+
+// loadSecrets decrypts secrets if encrypted, then applies
+// $STORY_CLIENT_SECRET and $STORY_ACCESS_TOKEN.
+func (c *InitConfig) loadSecrets() error {
+	clientSecret := os.Getenv("STORY_CLIENT_SECRET")
+	accessToken := os.Getenv("STORY_ACCESS_TOKEN")
+
+	if c.EncryptedSecrets == nil {
+		c.saved = &secrets{ClientSecret: c.ClientSecret, AccessToken: c.AccessToken}
+	} else if clientSecret == "" || accessToken == "" {
+		plain, err := c.decryptSecrets()
+		if err != nil {
+			return err
+		}
+
+		c.ClientSecret = plain.ClientSecret
+		c.AccessToken = plain.AccessToken
+		c.saved = plain
+	}
+
+	if clientSecret != "" {
+		c.ClientSecret = clientSecret
+		c.secretFromEnv = true
+	}
+
+	if accessToken != "" {
+		c.AccessToken = accessToken
+		c.tokenFromEnv = true
+	}
+
+	return nil
+}
+
+// decryptSecrets decrypts EncryptedSecrets with passphrase.
+func (c *InitConfig) decryptSecrets() (*secrets, error) {
+	passphrase, err := c.readPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := c.EncryptedSecrets.decrypt(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plain, nil
+}
+
+// storedConfig returns config to be saved. Secrets from environment variables
+// are not saved, and secrets are encrypted if encryption is enabled.
+func (c *InitConfig) storedConfig() (*InitConfig, error) {
+	stored := *c
+	if c.secretFromEnv || c.tokenFromEnv {
+		if c.saved == nil && c.secretFromEnv && c.tokenFromEnv {
+			// secrets are not decrypted nor changed, keep them as is
+			stored.ClientSecret = ""
+			stored.AccessToken = ""
+			return &stored, nil
+		}
+
+		if c.saved == nil {
+			// a secret is changed, like the token of new authorization,
+			// so the other one from environment is saved as decrypted
+			saved, err := c.decryptSecrets()
+			if err != nil {
+				return nil, err
+			}
+			c.saved = saved
+		}
+
+		if c.secretFromEnv {
+			stored.ClientSecret = c.saved.ClientSecret
+		}
+		if c.tokenFromEnv {
+			stored.AccessToken = c.saved.AccessToken
+		}
+	}
+
+	if !c.Encrypt && c.EncryptedSecrets == nil {
+		return &stored, nil
+	}
+
+	passphrase, err := c.readPassphrase(c.EncryptedSecrets == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stored.EncryptedSecrets, err = encryptSecrets(passphrase, secrets{ClientSecret: stored.ClientSecret, AccessToken: stored.AccessToken})
+	if err != nil {
+		return nil, err
+	}
+
+	stored.ClientSecret = ""
+	stored.AccessToken = ""
+	return &stored, nil
+}
@@ -1,37 +1,134 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/nullbus/story"
+	"golang.org/x/term"
 )
 
 func usageAndExit() {
 	write := func(args ...interface{}) { fmt.Fprintln(os.Stderr, args...) }
-	write("Usage: story <command> [options...]")
+	write("Usage: story [-config path] [-profile name] <command> [options...]")
 	write("  story init")
 	write("  story auth")
+	write("  story auth status")
 	write("  story info")
+	write("  story list")
+	write("  story categories")
 	write("  story show")
 	write("  story edit")
 	write("  story post")
+	write("  story cache ls")
+	write("  story cache prune")
 	write("")
 	write("-h for each command to get more information")
+	write("")
+	write("Global options:")
+	flag.PrintDefaults()
 
 	os.Exit(1)
 }
 
+var (
+	configPath    string
+	profile       string
+	retryAttempts int
+	retryDelay    time.Duration
+	retryUnsafe   bool
+)
+
+// loadConfig loads config of the profile, and sets its Retry from retry
+// settings of config and global options.
+func loadConfig() story.InitConfig {
+	config := story.InitConfig{Path: configPath, Profile: profile}
+	if err := config.Load(); err != nil {
+		log.Fatalln("failed to load config file, try `story init` first:", err)
+	}
+
+	policy, err := config.RetryPolicy()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "retry":
+			policy.MaxAttempts = retryAttempts
+		case "retry-delay":
+			policy.BaseDelay = retryDelay
+		case "retry-unsafe":
+			policy.RetryUnsafe = retryUnsafe
+		}
+	})
+	config.Retry = &policy
+
+	return config
+}
+
+func authorize(config *story.InitConfig) error {
+	if config.Manual {
+		return config.AuthorizeManual(os.Stdin, os.Stderr)
+	}
+
+	// stop waiting on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return config.Authorize(ctx)
+}
+
+// checkToken validates access token before mutating commands,
+// and offers to authorize again if the token is rejected.
+func checkToken(config *story.InitConfig) {
+	client := story.NewClient(config.AccessToken, "")
+	client.Retry = *config.Retry
+	_, err := client.ValidateToken()
+	if err == nil {
+		return
+	}
+
+	if !story.IsUnauthorized(err) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Fatalln(err)
+	}
+
+	fmt.Fprint(os.Stderr, "Access token is rejected. Authorize again? [y/N] ")
+	var answer string
+	fmt.Scanln(&answer)
+	if !strings.EqualFold(answer, "y") {
+		log.Fatalln(err)
+	}
+
+	if err := authorize(config); err != nil {
+		log.Fatalln(err)
+	}
+}
+
 func main() {
-	if len(os.Args) == 1 {
+	flag.StringVar(&configPath, "config", "", "config file path, $STORY_CONFIG or config.json in user config directory by default")
+	flag.StringVar(&profile, "profile", "", "config profile, $STORY_PROFILE or \"default\" by default")
+	flag.IntVar(&retryAttempts, "retry", story.DefaultRetryPolicy().MaxAttempts, "max attempts of api requests failed with 5xx, 429 or network error")
+	flag.DurationVar(&retryDelay, "retry-delay", story.DefaultRetryPolicy().BaseDelay, "delay before the first retry, doubled on each retry")
+	flag.BoolVar(&retryUnsafe, "retry-unsafe", false, "also retry writing new post, which may create duplicated posts")
+	flag.Usage = usageAndExit
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
 		usageAndExit()
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "init":
-		var config story.InitConfig
-		if err := config.Parse(os.Args[2:]); err != nil {
+		config := story.InitConfig{Path: configPath, Profile: profile}
+		if err := config.Parse(args[1:]); err != nil {
 			log.Fatalln(err)
 			return
 		}
@@ -46,42 +143,93 @@ func main() {
 		}
 
 		// authorize your client ID and save
-		if err := config.Authorize(); err != nil {
+		if err := authorize(&config); err != nil {
 			log.Fatalln(err)
 			return
 		}
 
+		if err := config.SelectDefaultBlog(os.Stdin, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+
 	case "auth":
-		var config story.InitConfig
-		if err := config.Load(); err != nil {
-			log.Fatalln("failed to load config file, try `story init` first")
+		config := loadConfig()
+
+		if len(args) > 1 && args[1] == "status" {
+			if err := config.PrintStatus(os.Stdout); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+
+		if err := config.ParseAuth(args[1:]); err != nil {
+			log.Fatalln(err)
+		}
+
+		if err := authorize(&config); err != nil {
+			log.Fatalln(err)
 		}
 
-		if err := config.Authorize(); err != nil {
+		if err := config.SelectDefaultBlog(os.Stdin, os.Stdout); err != nil {
 			log.Fatalln(err)
 		}
 
 	case "info":
-		var config story.InitConfig
-		if err := config.Load(); err != nil {
-			log.Fatalln("failed to load config file, try `story init` first")
+		config := loadConfig()
+
+		infoConfig := story.InfoConfig{Retry: config.Retry}
+		if err := infoConfig.Parse(args[1:]); err != nil {
+			log.Fatalln(err)
 		}
 
-		info, err := story.Info(config.AccessToken)
+		info, err := infoConfig.Do(config.AccessToken)
 		if err != nil {
 			log.Fatalln(err)
 		}
 
-		fmt.Println(info)
+		if err := infoConfig.Print(os.Stdout, info); err != nil {
+			log.Fatalln(err)
+		}
+
+	case "list":
+		baseConfig := loadConfig()
 
-	case "show":
-		var baseConfig story.InitConfig
-		if err := baseConfig.Load(); err != nil {
+		list := story.ListConfig{BlogName: baseConfig.DefaultBlog, Retry: baseConfig.Retry}
+		if err := list.Parse(args[1:]); err != nil {
 			log.Fatalln(err)
 		}
 
-		var show story.ViewConfig
-		if err := show.Parse(os.Args[2:]); err != nil {
+		posts, err := list.Do(baseConfig.AccessToken)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if err := list.Print(os.Stdout, posts); err != nil {
+			log.Fatalln(err)
+		}
+
+	case "categories":
+		baseConfig := loadConfig()
+
+		categories := story.CategoriesConfig{BlogName: baseConfig.DefaultBlog, Retry: baseConfig.Retry}
+		if err := categories.Parse(args[1:]); err != nil {
+			log.Fatalln(err)
+		}
+
+		items, err := categories.Do(baseConfig.AccessToken)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if err := categories.Print(os.Stdout, items); err != nil {
+			log.Fatalln(err)
+		}
+
+	case "show":
+		baseConfig := loadConfig()
+
+		show := story.ViewConfig{BlogName: baseConfig.DefaultBlog, Retry: baseConfig.Retry}
+		if err := show.Parse(args[1:]); err != nil {
 			log.Fatalln(err)
 		}
 
@@ -93,40 +241,42 @@ func main() {
 		fmt.Printf("%+v\n", post)
 
 	case "edit":
-		var baseConfig story.InitConfig
-		if err := baseConfig.Load(); err != nil {
-			log.Println("failed to load config file, try `story init` first")
-			os.Exit(1)
-			return
-		}
+		baseConfig := loadConfig()
 
-		var edit story.EditConfig
-		if err := edit.Parse(os.Args[2:]); err != nil {
+		edit := story.EditConfig{BlogName: baseConfig.DefaultBlog, Retry: baseConfig.Retry}
+		if err := edit.Parse(args[1:]); err != nil {
 			log.Fatalln(err)
 		}
 
+		checkToken(&baseConfig)
 		if err := edit.Do(baseConfig.AccessToken); err != nil {
 			log.Fatalln(err)
 		}
 
 	case "post":
-		var baseConfig story.InitConfig
-		if err := baseConfig.Load(); err != nil {
-			log.Println("failed to load config file, try `story init` first")
-			os.Exit(1)
-			return
-		}
+		baseConfig := loadConfig()
 
-		var post story.PostConfig
-		if err := post.Parse(os.Args[2:]); err != nil {
+		post := story.PostConfig{BlogName: baseConfig.DefaultBlog, Retry: baseConfig.Retry}
+		if err := post.Parse(args[1:]); err != nil {
 			log.Fatalln(err)
 			return
 		}
 
+		checkToken(&baseConfig)
 		if err := post.Do(baseConfig.AccessToken); err != nil {
 			log.Fatalln(err)
 		}
 
+	case "cache":
+		var cache story.CacheConfig
+		if err := cache.Parse(args[1:]); err != nil {
+			log.Fatalln(err)
+		}
+
+		if err := cache.Do(os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+
 	default:
 		usageAndExit()
 	}